@@ -0,0 +1,187 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/term"
+	runtimeschema "k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/restmapper"
+)
+
+// apiVersionMove is a kind which moved to a different API group, the version the cluster serves can't be
+// derived from the discovery data of the old group
+type apiVersionMove struct {
+	apiVersion string
+	kind       string
+}
+
+// apiVersionMoves are the stable apiVersions of kinds whose deprecated apiVersion belongs to a different group,
+// or which are used when the cluster can't be asked
+var apiVersionMoves = map[apiVersionMove]string{
+	{"extensions/v1beta1", "Deployment"}:                                       "apps/v1",
+	{"extensions/v1beta1", "DaemonSet"}:                                        "apps/v1",
+	{"extensions/v1beta1", "ReplicaSet"}:                                       "apps/v1",
+	{"apps/v1beta1", "Deployment"}:                                             "apps/v1",
+	{"apps/v1beta1", "StatefulSet"}:                                            "apps/v1",
+	{"apps/v1beta2", "Deployment"}:                                             "apps/v1",
+	{"apps/v1beta2", "StatefulSet"}:                                            "apps/v1",
+	{"apps/v1beta2", "DaemonSet"}:                                              "apps/v1",
+	{"apps/v1beta2", "ReplicaSet"}:                                             "apps/v1",
+	{"extensions/v1beta1", "Ingress"}:                                          "networking.k8s.io/v1",
+	{"networking.k8s.io/v1beta1", "Ingress"}:                                   "networking.k8s.io/v1",
+	{"networking.k8s.io/v1beta1", "IngressClass"}:                              "networking.k8s.io/v1",
+	{"extensions/v1beta1", "NetworkPolicy"}:                                    "networking.k8s.io/v1",
+	{"policy/v1beta1", "PodDisruptionBudget"}:                                  "policy/v1",
+	{"batch/v1beta1", "CronJob"}:                                               "batch/v1",
+	{"autoscaling/v2beta1", "HorizontalPodAutoscaler"}:                         "autoscaling/v2",
+	{"autoscaling/v2beta2", "HorizontalPodAutoscaler"}:                         "autoscaling/v2",
+	{"rbac.authorization.k8s.io/v1beta1", "Role"}:                              "rbac.authorization.k8s.io/v1",
+	{"rbac.authorization.k8s.io/v1beta1", "RoleBinding"}:                       "rbac.authorization.k8s.io/v1",
+	{"rbac.authorization.k8s.io/v1beta1", "ClusterRole"}:                       "rbac.authorization.k8s.io/v1",
+	{"rbac.authorization.k8s.io/v1beta1", "ClusterRoleBinding"}:                "rbac.authorization.k8s.io/v1",
+	{"apiextensions.k8s.io/v1beta1", "CustomResourceDefinition"}:               "apiextensions.k8s.io/v1",
+	{"storage.k8s.io/v1beta1", "StorageClass"}:                                 "storage.k8s.io/v1",
+	{"scheduling.k8s.io/v1beta1", "PriorityClass"}:                             "scheduling.k8s.io/v1",
+	{"admissionregistration.k8s.io/v1beta1", "ValidatingWebhookConfiguration"}: "admissionregistration.k8s.io/v1",
+	{"admissionregistration.k8s.io/v1beta1", "MutatingWebhookConfiguration"}:   "admissionregistration.k8s.io/v1",
+}
+
+// apiVersionUpgrade is a deprecated apiVersion of a kind in the manifest and the version to use instead
+type apiVersionUpgrade struct {
+	kind string
+	from string
+	to   string
+}
+
+// servedVersions are the kinds the cluster serves per apiVersion and the preferred version of every group
+type servedVersions struct {
+	kinds     map[string]map[string]bool
+	preferred map[string]string
+}
+
+// discoverServedVersions asks the cluster which apiVersions it serves.
+func discoverServedVersions() (*servedVersions, error) {
+	c, err := getClientset()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := restmapper.GetAPIGroupResources(c.Discovery())
+	if err != nil {
+		return nil, err
+	}
+	served := &servedVersions{kinds: map[string]map[string]bool{}, preferred: map[string]string{}}
+	for _, g := range groups {
+		served.preferred[g.Group.Name] = runtimeschema.GroupVersion{Group: g.Group.Name, Version: g.Group.PreferredVersion.Version}.String()
+		for version, resources := range g.VersionedResources {
+			apiVersion := runtimeschema.GroupVersion{Group: g.Group.Name, Version: version}.String()
+			kinds := map[string]bool{}
+			for _, r := range resources {
+				kinds[r.Kind] = true
+			}
+			served.kinds[apiVersion] = kinds
+		}
+	}
+	return served, nil
+}
+
+// unstableVersion reports whether the version of an apiVersion is an alpha or beta version.
+func unstableVersion(apiVersion string) bool {
+	gv, err := runtimeschema.ParseGroupVersion(apiVersion)
+	return err == nil && (strings.Contains(gv.Version, "alpha") || strings.Contains(gv.Version, "beta"))
+}
+
+// deprecatedAPIVersions returns the upgrades for the objects of the manifest whose apiVersion the cluster doesn't
+// serve anymore, or which is an alpha or beta version while the cluster prefers a different version of the group.
+// Without served versions, e.g. when the cluster can't be reached, apiVersionMoves is used on its own.
+func deprecatedAPIVersions(completion string, served *servedVersions) ([]apiVersionUpgrade, error) {
+	objects, err := decodeManifest(completion)
+	if err != nil {
+		return nil, err
+	}
+
+	var upgrades []apiVersionUpgrade
+	seen := map[apiVersionUpgrade]bool{}
+	for _, obj := range objects {
+		from, kind := obj.GetAPIVersion(), obj.GetKind()
+		to := ""
+		switch {
+		case served == nil:
+			to = apiVersionMoves[apiVersionMove{from, kind}]
+		case served.kinds[from][kind]:
+			//served, but the cluster may prefer a newer version of the group
+			if preferred := served.preferred[obj.GroupVersionKind().Group]; unstableVersion(from) && preferred != from && served.kinds[preferred][kind] {
+				to = preferred
+			}
+		default:
+			if moved, ok := apiVersionMoves[apiVersionMove{from, kind}]; ok && served.kinds[moved][kind] {
+				to = moved
+			} else if preferred := served.preferred[obj.GroupVersionKind().Group]; served.kinds[preferred][kind] {
+				to = preferred
+			}
+		}
+		upgrade := apiVersionUpgrade{kind: kind, from: from, to: to}
+		if to != "" && !seen[upgrade] {
+			seen[upgrade] = true
+			upgrades = append(upgrades, upgrade)
+		}
+	}
+	return upgrades, nil
+}
+
+// rewriteAPIVersions sets the apiVersion of every object with an upgrade to the new version. Only the apiVersion
+// is rewritten, fields which changed between the versions have to be fixed by reprompting.
+func rewriteAPIVersions(completion string, upgrades []apiVersionUpgrade) (string, error) {
+	objects, err := decodeManifest(completion)
+	if err != nil {
+		return "", err
+	}
+	for _, obj := range objects {
+		for _, u := range upgrades {
+			if obj.GetAPIVersion() == u.from && obj.GetKind() == u.kind {
+				obj.SetAPIVersion(u.to)
+				break
+			}
+		}
+	}
+	return encodeManifest(objects)
+}
+
+// upgradeAPIVersions detects deprecated apiVersions in the manifest and shows how they map to the current ones.
+// With the upgrade-api-versions flag they are rewritten right away, in interactive runs the user is asked,
+// otherwise the manifest is returned unchanged.
+func upgradeAPIVersions(completion string) (string, error) {
+	served, err := discoverServedVersions()
+	if err != nil {
+		log.Debugf("unable to discover the served apiVersions, using the known deprecations: %v", err)
+		served = nil
+	}
+	upgrades, err := deprecatedAPIVersions(completion, served)
+	if err != nil || len(upgrades) == 0 {
+		return completion, err
+	}
+
+	log.Warnf("the manifest uses deprecated apiVersions:")
+	for _, u := range upgrades {
+		log.Warnf("  %s: %s -> %s", u.kind, u.from, u.to)
+	}
+	if !*upgradeAPIs {
+		if !*requireConfirmation || !term.IsTerminal(int(os.Stdin.Fd())) {
+			return completion, nil
+		}
+		prompt := promptui.Prompt{Label: "Would you like to rewrite them", IsConfirm: true}
+		//promptui returns an error if the user doesn't confirm
+		if _, err := prompt.Run(); err != nil {
+			return completion, nil
+		}
+	}
+	rewritten, err := rewriteAPIVersions(completion, upgrades)
+	if err != nil {
+		return "", fmt.Errorf("unable to rewrite the apiVersions: %w", err)
+	}
+	log.Infof("rewrote %d deprecated apiVersions, reprompt if fields of the new versions differ", len(upgrades))
+	return rewritten, nil
+}
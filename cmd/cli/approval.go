@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+	log "github.com/sirupsen/logrus"
+)
+
+// approvalProvider decides whether a generated manifest is applied, it returns one of the actions of
+// userActionPrompt. The terminal prompt is the default, headless environments can use a webhook.
+type approvalProvider interface {
+	approve(ctx context.Context, completion string) (string, error)
+	// approveUndo decides whether the undo command reverts the last apply, plan lists what it restores and deletes
+	approveUndo(ctx context.Context, plan string) (bool, error)
+	// approveObject decides about a single object with the confirm-each flag, it returns apply, skip or abortAll.
+	// ref identifies the object like kubectl does, manifest is the object as YAML.
+	approveObject(ctx context.Context, ref, manifest string) (string, error)
+}
+
+// newApprovalProvider returns the webhook approval with the approval-webhook flag, the terminal prompt otherwise.
+func newApprovalProvider() approvalProvider {
+	if *approvalWebhook != "" {
+		return webhookApproval{
+			url:    *approvalWebhook,
+			client: &http.Client{Timeout: *approvalTimeout, Transport: userAgentTransport{next: http.DefaultTransport}},
+		}
+	}
+	return terminalApproval{}
+}
+
+// terminalApproval asks the user in the terminal, see userActionPrompt
+type terminalApproval struct{}
+
+// approve implements approvalProvider.
+func (terminalApproval) approve(_ context.Context, _ string) (string, error) {
+	return userActionPrompt()
+}
+
+// approveUndo implements approvalProvider. Like an apply an undo is confirmed unless require-confirmation is
+// off, and without a terminal the answer is read from stdin. Protected contexts need the typed confirmation.
+func (terminalApproval) approveUndo(_ context.Context, _ string) (bool, error) {
+	if *requireConfirmation {
+		label := "Would you like to undo this"
+		if promptTerminal() {
+			prompt := promptui.Prompt{Label: label, IsConfirm: true}
+			//promptui returns an error if the user doesn't confirm
+			if _, err := prompt.Run(); err != nil {
+				return false, nil
+			}
+		} else if ok, err := plainConfirm(label); err != nil || !ok {
+			return false, err
+		}
+	}
+	action, err := confirmProtectedContext()
+	return action == apply, err
+}
+
+// approveObject implements approvalProvider, see objectActionPrompt.
+func (terminalApproval) approveObject(_ context.Context, ref, _ string) (string, error) {
+	return objectActionPrompt(ref)
+}
+
+// approvalRequest is the body posted to the approval webhook
+type approvalRequest struct {
+	// Action is apply, delete or undo
+	Action   string `json:"action"`
+	Context  string `json:"context,omitempty"`
+	Manifest string `json:"manifest"`
+	// Protected is set for contexts matching the protected-contexts flag, which need extra care
+	Protected bool `json:"protected,omitempty"`
+	// TraceID identifies the run in the logs and the audit log
+	TraceID string `json:"traceID,omitempty"`
+}
+
+// approvalResponse is the answer of the approval webhook
+type approvalResponse struct {
+	// Action is apply or dont-apply
+	Action string `json:"action"`
+}
+
+// approval actions of the webhook response
+const (
+	approvalApply     = "apply"
+	approvalDontApply = "dont-apply"
+)
+
+// webhookApproval posts the manifest to a webhook and waits for its decision, e.g. a bridge to a chat
+// where a human approves, so approval works without a terminal
+type webhookApproval struct {
+	url    string
+	client *http.Client
+}
+
+// approve implements approvalProvider. Anything but an explicit apply, including errors, doesn't apply.
+// The webhook replaces the typed confirmation of protected contexts, it's told about them instead.
+func (w webhookApproval) approve(ctx context.Context, completion string) (string, error) {
+	action := "apply"
+	if *deleteMode {
+		action = "delete"
+	}
+	return w.decide(ctx, action, completion)
+}
+
+// approveUndo implements approvalProvider, the manifest of the request is the plan of the undo.
+func (w webhookApproval) approveUndo(ctx context.Context, plan string) (bool, error) {
+	action, err := w.decide(ctx, "undo", plan)
+	return action == apply, err
+}
+
+// approveObject implements approvalProvider. An object the webhook doesn't approve is skipped, errors abort the rest.
+func (w webhookApproval) approveObject(ctx context.Context, _, manifest string) (string, error) {
+	action := "apply"
+	if *deleteMode {
+		action = "delete"
+	}
+	result, err := w.decide(ctx, action, manifest)
+	if err != nil {
+		return abortAll, err
+	}
+	if result != apply {
+		return skip, nil
+	}
+	return apply, nil
+}
+
+// decide posts the request for the action to the webhook and returns its decision as an action of userActionPrompt.
+func (w webhookApproval) decide(ctx context.Context, action, manifest string) (string, error) {
+	request := approvalRequest{Action: action, Manifest: manifest, TraceID: traceID}
+	if name, protected := protectedContext(); protected {
+		request.Context, request.Protected = name, true
+	} else if name, err := getCurrentContextName(); err == nil {
+		request.Context = name
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return dontApply, err
+	}
+
+	fmt.Printf("%sWaiting for approval from %s\n", emoji("⏳"), w.url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return dontApply, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return dontApply, fmt.Errorf("approval webhook failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return dontApply, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return dontApply, fmt.Errorf("approval webhook returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var approval approvalResponse
+	if err := json.Unmarshal(data, &approval); err != nil {
+		return dontApply, fmt.Errorf("unable to parse the approval webhook response: %w", err)
+	}
+	log.Debugf("approval webhook answered %q", approval.Action)
+	switch approval.Action {
+	case approvalApply:
+		//like a confirmation at the terminal, destructive steps like on-immutable=replace may run
+		applyConfirmed = true
+		return apply, nil
+	case approvalDontApply:
+		fmt.Printf("The %s was not approved\n", action)
+		return dontApply, nil
+	}
+	return dontApply, fmt.Errorf("approval webhook answered %q, expected %s or %s", approval.Action, approvalApply, approvalDontApply)
+}
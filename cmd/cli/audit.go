@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// what happened to a generated manifest, recorded in the audit log
+const (
+	auditApplied  = "applied"
+	auditDeleted  = "deleted"
+	auditDeclined = "declined"
+	auditAborted  = "aborted"
+	auditPrinted  = "printed"
+	auditWritten  = "written"
+)
+
+// auditEntry is a single line of the audit log
+type auditEntry struct {
+	Time           time.Time `json:"time"`
+	Prompt         string    `json:"prompt"`
+	Action         string    `json:"action"`
+	ManifestSHA256 string    `json:"manifestSHA256"`
+	TraceID        string    `json:"traceID,omitempty"`
+	completionInfo
+}
+
+// writeAuditLog appends what happened to the generated manifest, together with the prompt and the
+// model which generated it, to the audit log. Nothing is written if the audit-log flag is not set.
+func writeAuditLog(prompt, completion string, info completionInfo, action string) error {
+	if *auditLog == "" {
+		return nil
+	}
+
+	entry := auditEntry{
+		Time:           time.Now(),
+		Prompt:         prompt,
+		Action:         action,
+		ManifestSHA256: manifestSHA256(completion),
+		TraceID:        traceID,
+		completionInfo: info,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*auditLog), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(*auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	//one JSON object per line, so the log can be processed with tools like jq
+	return json.NewEncoder(f).Encode(entry)
+}
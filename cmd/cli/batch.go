@@ -0,0 +1,218 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// maxSlugLength keeps the file names of batch outputs readable
+const maxSlugLength = 40
+
+// nonSlugChars matches everything which doesn't belong into a file name
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// readBatchFile returns the prompts of the batch file, one per line. Empty lines and lines starting with # are skipped.
+func readBatchFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read batch file: %w", err)
+	}
+	defer f.Close()
+
+	var prompts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		prompts = append(prompts, line)
+	}
+	return prompts, scanner.Err()
+}
+
+// runBatch generates a manifest for every prompt of the batch file. With the batch-output-dir flag every
+// manifest is written to its own file, e.g. 01-create-an-nginx-deployment.yaml, otherwise every manifest
+// goes through the usual confirmation and apply. The args are added to every prompt. A failing prompt
+// doesn't stop the batch, the result of every prompt is reported at the end.
+func runBatch(args []string) error {
+	prompts, err := readBatchFile(*batchFile)
+	if err != nil {
+		return err
+	}
+	if len(prompts) == 0 {
+		return fmt.Errorf("batch file %s has no prompts", *batchFile)
+	}
+	if *batchOutputDir != "" {
+		if err := os.MkdirAll(*batchOutputDir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	//every request to the model of the batch waits for the rate limit, see rateLimitTransport
+	if *batchRateLimit > 0 {
+		concurrency := *batchConcurrency
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		//a token bucket, the burst allows every worker to start right away
+		requestLimiter = rate.NewLimiter(rate.Limit(float64(*batchRateLimit)/time.Minute.Seconds()), concurrency)
+		defer func() { requestLimiter = nil }()
+	}
+
+	//the manifests are generated ahead, concurrently and within the rate limit, and handed to run by the loop below
+	prefetched := make([]*prefetchedCompletion, len(prompts))
+	if *batchConcurrency > 1 || *batchRateLimit > 0 {
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+		if err := prefetchBatchCompletions(ctx, prompts, args, prefetched); err != nil {
+			return err
+		}
+	}
+
+	var failed int
+	results := make([]string, len(prompts))
+	for i, prompt := range prompts {
+		fmt.Printf("%s[%d/%d] %s\n", emoji("📦"), i+1, len(prompts), prompt)
+		if *batchOutputDir != "" {
+			name := fmt.Sprintf("%02d-%s", i+1, slug(prompt))
+			//with split every manifest gets a directory for its documents
+			if !*split {
+				name += ".yaml"
+			}
+			*outputFile = filepath.Join(*batchOutputDir, name)
+		}
+		if err := recordPrompt(prompt); err != nil {
+			log.Debugf("unable to record prompt: %v", err)
+		}
+		if err := runPrefetched(append([]string{prompt + " "}, args...), prefetched[i]); err != nil {
+			log.Errorf("prompt %d failed: %v", i+1, err)
+			results[i] = "failed: " + err.Error()
+			failed++
+			continue
+		}
+		results[i] = "ok"
+		if *batchOutputDir != "" {
+			results[i] = "written to " + *outputFile
+		}
+	}
+
+	fmt.Printf("\nBatch summary, %d of %d prompts succeeded:\n", len(prompts)-failed, len(prompts))
+	for i, prompt := range prompts {
+		fmt.Printf("%d. %s: %s\n", i+1, prompt, results[i])
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d prompts failed", failed, len(prompts))
+	}
+	return nil
+}
+
+// prefetchedCompletion is a manifest generated ahead by prefetchBatchCompletions
+type prefetchedCompletion struct {
+	completion string
+	info       completionInfo
+	err        error
+}
+
+// requestLimiter limits the requests to the model to batch-requests-per-minute during a batch, nil otherwise
+var requestLimiter *rate.Limiter
+
+// rateLimitTransport waits for the limiter before passing every request on to next
+type rateLimitTransport struct {
+	limiter *rate.Limiter
+	next    http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
+
+// prefetchBatchCompletions generates the manifests of the prompts with batch-concurrency prompts at a time and
+// stores them in prefetched by the index of the prompt. The requests are built like run builds them, run gets
+// the manifest instead of calling the model for its first generation. Every prompt reports on stderr when it
+// starts and finishes, so the progress doesn't end up in piped output.
+func prefetchBatchCompletions(ctx context.Context, prompts, args []string, prefetched []*prefetchedCompletion) error {
+	client, err := newOAIClients()
+	if err != nil {
+		return err
+	}
+
+	concurrency := *batchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var mu sync.Mutex
+	report := func(format string, a ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(os.Stderr, format, a...)
+	}
+
+	//the conversations of the batch share the cached schemas, they are generated for the same cluster
+	schemas.reset()
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				report("%s[%d/%d] generating: %s\n", emoji("⏳"), i+1, len(prompts), prompts[i])
+				start := time.Now()
+				request, err := requestArgs(ctx, append([]string{prompts[i] + " "}, args...))
+				var completion string
+				var info completionInfo
+				if err == nil {
+					completion, info, err = gptCompletion(ctx, client, request, *openAIDeploymentName, func(delay time.Duration, attempt int) {
+						report("%s[%d/%d] rate limited, retrying in %s (attempt %d/%d)\n", emoji("⏳"), i+1, len(prompts), delay.Round(time.Second), attempt, maxRetries)
+					})
+				}
+				if err != nil {
+					report("%s[%d/%d] generation failed: %v\n", emoji("❌"), i+1, len(prompts), err)
+				} else {
+					report("%s[%d/%d] generated in %s\n", emoji("✅"), i+1, len(prompts), time.Since(start).Round(100*time.Millisecond))
+				}
+				//every worker writes the index of its own prompts only
+				prefetched[i] = &prefetchedCompletion{completion: completion, info: info, err: err}
+			}
+		}()
+	}
+	for i := range prompts {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return ctx.Err()
+}
+
+// slug returns a file name friendly version of the prompt, e.g. create-an-nginx-deployment.
+func slug(prompt string) string {
+	s := strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(prompt), "-"), "-")
+	if len(s) > maxSlugLength {
+		s = strings.TrimRight(s[:maxSlugLength], "-")
+	}
+	if s == "" {
+		return "manifest"
+	}
+	return s
+}
@@ -0,0 +1,60 @@
+package cli
+
+import (
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// defaultJobRestartPolicy is set on Jobs without a valid restartPolicy, Jobs only accept OnFailure and Never
+const defaultJobRestartPolicy = "OnFailure"
+
+// jobSpecPaths are the paths to the Job spec of the batch kinds
+var jobSpecPaths = map[string][]string{
+	"Job":     {"spec"},
+	"CronJob": {"spec", "jobTemplate", "spec"},
+}
+
+// addBatchDefaults makes sure every Job and CronJob of the manifest has a restartPolicy a Job accepts and a
+// backoffLimit, set to the batch-backoff-limit flag if missing. Every fix of the model's output is logged as
+// a warning. The manifest is returned unchanged if nothing had to be fixed.
+func addBatchDefaults(completion string) (string, error) {
+	objects, err := decodeManifest(completion)
+	if err != nil {
+		return "", err
+	}
+
+	fixed := false
+	for _, obj := range objects {
+		specPath, ok := jobSpecPaths[obj.GetKind()]
+		if !ok {
+			continue
+		}
+		policyPath := fieldPath(specPath, "template", "spec", "restartPolicy")
+		policy, _, _ := unstructured.NestedString(obj.Object, policyPath...)
+		if policy != "OnFailure" && policy != "Never" {
+			if policy == "" {
+				log.Warnf("%s/%s has no restartPolicy, setting it to %s", obj.GetKind(), obj.GetName(), defaultJobRestartPolicy)
+			} else {
+				log.Warnf("%s/%s has the restartPolicy %s which Jobs don't accept, setting it to %s", obj.GetKind(), obj.GetName(), policy, defaultJobRestartPolicy)
+			}
+			if err := unstructured.SetNestedField(obj.Object, defaultJobRestartPolicy, policyPath...); err != nil {
+				return "", err
+			}
+			fixed = true
+		}
+
+		backoffPath := fieldPath(specPath, "backoffLimit")
+		if _, found, _ := unstructured.NestedFieldNoCopy(obj.Object, backoffPath...); !found {
+			log.Warnf("%s/%s has no backoffLimit, setting it to %d", obj.GetKind(), obj.GetName(), *batchBackoffLimit)
+			if err := unstructured.SetNestedField(obj.Object, int64(*batchBackoffLimit), backoffPath...); err != nil {
+				return "", err
+			}
+			fixed = true
+		}
+	}
+	if !fixed {
+		return completion, nil
+	}
+
+	return encodeManifest(objects)
+}
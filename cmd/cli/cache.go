@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cachedResponse is a completion stored in the response cache
+type cachedResponse struct {
+	Time       time.Time      `json:"time"`
+	Completion string         `json:"completion"`
+	Info       completionInfo `json:"info"`
+}
+
+// responseCacheKey identifies a request in the response cache. The instructions contain everything the flags
+// add to the prompt, together with the request, the endpoint and the model settings they determine the response.
+func responseCacheKey(instructions, request, deploymentName string, temp float32) string {
+	h := sha256.New()
+	for _, part := range []string{
+		instructions,
+		request,
+		*openAIEndpoint,
+		deploymentName,
+		fmt.Sprint(temp),
+		*completionAPI,
+		fmt.Sprint(*usek8sAPI),
+		fmt.Sprint(*noFunctions),
+	} {
+		//the length prefix keeps e.g. "ab"+"c" and "a"+"bc" apart
+		fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseCacheFile returns the path of a cached response in the user's cache directory.
+func responseCacheFile(key string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "kubectl-assistant", "responses", key+".json"), nil
+}
+
+// readResponseCache returns the cached response of a request if there is one younger than the cache-ttl.
+// The cache is only read with the cache flag and never with the no-cache flag.
+func readResponseCache(key string) (string, completionInfo, bool) {
+	if !*cache || *noCache {
+		return "", completionInfo{}, false
+	}
+	path, err := responseCacheFile(key)
+	if err != nil {
+		return "", completionInfo{}, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", completionInfo{}, false
+	}
+	var cached cachedResponse
+	if err := json.Unmarshal(data, &cached); err != nil {
+		log.Debugf("ignoring invalid cached response %s: %v", path, err)
+		return "", completionInfo{}, false
+	}
+	if *cacheTTL > 0 && time.Since(cached.Time) > *cacheTTL {
+		log.Debugf("cached response %s expired", path)
+		_ = os.Remove(path)
+		return "", completionInfo{}, false
+	}
+	log.Debugf("using cached response %s", path)
+	return cached.Completion, cached.Info, true
+}
+
+// writeResponseCache stores the response of a request with the cache flag. With the no-cache flag the response
+// is stored too, so bypassing the cache refreshes it.
+func writeResponseCache(key, completion string, info completionInfo) {
+	if !*cache {
+		return
+	}
+	path, err := responseCacheFile(key)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0o700)
+	}
+	var data []byte
+	if err == nil {
+		data, err = json.Marshal(cachedResponse{Time: time.Now(), Completion: completion, Info: info})
+	}
+	if err == nil {
+		err = os.WriteFile(path, data, 0o600)
+	}
+	//the manifest was generated fine, a broken cache shouldn't fail the run
+	if err != nil {
+		log.Debugf("unable to cache the response: %v", err)
+	}
+}
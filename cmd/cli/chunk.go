@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"strings"
+)
+
+// charsPerToken is a rough estimate of how many characters make up a token for English text and YAML,
+// it's good enough to decide whether a prompt fits without shipping a tokenizer for every model
+const charsPerToken = 4
+
+// partInstruction tells the model which part of a split prompt it is generating
+const partInstruction = "This is part %d of %d of a larger request, the other parts are generated separately. Only generate the resources described in this part. "
+
+// partInstructionTokens is the estimated size of partInstruction, with room for the part numbers
+var partInstructionTokens = estimateTokens(partInstruction) + 2
+
+// schemaFixInstructions starts the request to fix the schema errors of the generated manifest, followed by it
+const schemaFixInstructions = "\nThe following manifest you generated has schema validation errors:\n"
+
+// requestContext reports whether the prompt is context of the request rather than the user's text: the live
+// objects of context-resources, the diagnostic information of fix or a generated manifest to correct. The
+// context is sent with every part of a split prompt, splitting it would separate the data from its framing.
+func requestContext(prompt string) bool {
+	return strings.HasPrefix(prompt, contextResourcesInstructions) ||
+		strings.HasPrefix(prompt, fixInstructions) ||
+		strings.HasPrefix(prompt, schemaFixInstructions)
+}
+
+// contextWindows are the context window sizes in tokens of known models, matched by prefix.
+// More specific prefixes have to come first.
+var contextWindows = []struct {
+	prefix string
+	tokens int
+}{
+	{"gpt-4o", 128000},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4-1106", 128000},
+	{"gpt-4-0125", 128000},
+	{"gpt-4-32k", 32768},
+	{"gpt-4", 8192},
+	{"gpt-35-turbo-16k", 16385},
+	{"gpt-3.5-turbo-16k", 16385},
+	{"gpt-3.5-turbo-1106", 16385},
+	{"gpt-3.5-turbo-0125", 16385},
+	{"gpt-35-turbo", 4096},
+	{"gpt-3.5-turbo", 4096},
+	{"code-davinci-002", 8001},
+	{"text-davinci-003", 4097},
+}
+
+// promptTokenLimit returns how many tokens the prompt for the model may have, a quarter of the
+// context window is left for the generated manifest. It returns 0 if the model is unknown and the
+// context-window flag isn't set, in which case prompts are never split.
+func promptTokenLimit(deploymentName string) int {
+	window := *contextWindow
+	if window == 0 {
+		for _, w := range contextWindows {
+			if strings.HasPrefix(deploymentName, w.prefix) {
+				window = w.tokens
+				break
+			}
+		}
+	}
+	return window * 3 / 4
+}
+
+// estimateTokens estimates the number of tokens of the text.
+func estimateTokens(text string) int {
+	return (len(text) + charsPerToken - 1) / charsPerToken
+}
+
+// splitPrompt splits the prompt into parts of at most maxTokens estimated tokens. It splits between
+// paragraphs, which usually describe separate resources, and between lines of paragraphs that are too
+// large themselves. A single line larger than maxTokens becomes a part of its own.
+func splitPrompt(prompt string, maxTokens int) []string {
+	var pieces []string
+	for _, paragraph := range strings.Split(prompt, "\n\n") {
+		if estimateTokens(paragraph) <= maxTokens {
+			pieces = append(pieces, paragraph)
+			continue
+		}
+		pieces = append(pieces, strings.Split(paragraph, "\n")...)
+	}
+
+	//pack the pieces greedily, so we make as few calls as possible
+	var parts []string
+	var current strings.Builder
+	for _, piece := range pieces {
+		if strings.TrimSpace(piece) == "" {
+			continue
+		}
+		if current.Len() > 0 && estimateTokens(current.String()+"\n\n"+piece) > maxTokens {
+			parts = append(parts, current.String())
+			current.Reset()
+		}
+		if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(piece)
+	}
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// cloud providers supported by the cloud flag, auto detects the provider from the cluster's nodes
+const (
+	cloudAuto  = "auto"
+	cloudAWS   = "aws"
+	cloudGCP   = "gcp"
+	cloudAzure = "azure"
+)
+
+// cloudGuidance is added to the prompt so generated Services and Ingresses use the
+// annotations of the managed load balancers of each cloud provider
+var cloudGuidance = map[string]string{
+	cloudAWS:   "The cluster runs on Amazon EKS. For Services of type LoadBalancer use the AWS Load Balancer Controller annotations, e.g. service.beta.kubernetes.io/aws-load-balancer-type: external, service.beta.kubernetes.io/aws-load-balancer-nlb-target-type: ip and service.beta.kubernetes.io/aws-load-balancer-scheme. For Ingresses use ingressClassName: alb with alb.ingress.kubernetes.io/scheme and alb.ingress.kubernetes.io/target-type annotations. ",
+	cloudGCP:   "The cluster runs on Google Kubernetes Engine. For internal Services of type LoadBalancer use the networking.gke.io/load-balancer-type: Internal annotation, and add cloud.google.com/neg: '{\"ingress\": true}' to Services behind an Ingress. For Ingresses use the kubernetes.io/ingress.class: gce (or gce-internal) annotation. ",
+	cloudAzure: "The cluster runs on Azure Kubernetes Service. For internal Services of type LoadBalancer use the service.beta.kubernetes.io/azure-load-balancer-internal: \"true\" annotation and service.beta.kubernetes.io/azure-dns-label-name for public DNS names. For Ingresses use ingressClassName: webapprouting.kubernetes.azure.com. ",
+}
+
+// validateCloud checks that the cloud flag is one of the supported providers.
+func validateCloud(cloud string) error {
+	if cloud == "" || cloud == cloudAuto {
+		return nil
+	}
+	if _, ok := cloudGuidance[cloud]; !ok {
+		return fmt.Errorf("--cloud must be one of %s, %s, %s or %s, got %q", cloudAuto, cloudAWS, cloudGCP, cloudAzure, cloud)
+	}
+	return nil
+}
+
+// resolveCloud returns the cloud provider to generate manifests for.
+// With auto the provider is detected from the cluster's nodes, if that's not possible no provider is used.
+func resolveCloud(ctx context.Context, cloud string) string {
+	if cloud != cloudAuto {
+		return cloud
+	}
+	detected, err := detectCloud(ctx)
+	if err != nil {
+		log.Debugf("unable to detect cloud provider: %v", err)
+		return ""
+	}
+	log.Debugf("detected cloud provider: %s", detected)
+	return detected
+}
+
+// detectCloud detects the cloud provider from the provider ID and labels of the first node.
+// It returns an empty string if the nodes don't belong to a known managed service.
+func detectCloud(ctx context.Context) (string, error) {
+	c, err := getClientset()
+	if err != nil {
+		return "", err
+	}
+	nodes, err := c.CoreV1().Nodes().List(ctx, metav1.ListOptions{Limit: 1})
+	if err != nil {
+		return "", err
+	}
+	if len(nodes.Items) == 0 {
+		return "", nil
+	}
+
+	node := nodes.Items[0]
+	//the provider ID is set by the cloud controller manager, e.g. aws:///eu-west-1a/i-0abc
+	switch {
+	case strings.HasPrefix(node.Spec.ProviderID, "aws://"):
+		return cloudAWS, nil
+	case strings.HasPrefix(node.Spec.ProviderID, "gce://"):
+		return cloudGCP, nil
+	case strings.HasPrefix(node.Spec.ProviderID, "azure://"):
+		return cloudAzure, nil
+	}
+	//fall back to the labels the managed node pools set
+	for label := range node.Labels {
+		switch {
+		case strings.HasPrefix(label, "eks.amazonaws.com/"):
+			return cloudAWS, nil
+		case strings.HasPrefix(label, "cloud.google.com/gke-"):
+			return cloudGCP, nil
+		case strings.HasPrefix(label, "kubernetes.azure.com/"):
+			return cloudAzure, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"context"
+	"reflect"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// serverManagedMetadata are the fields of the metadata the server may change without a change of the object
+var serverManagedMetadata = []string{"managedFields", "resourceVersion", "generation"}
+
+// isUnchanged reports whether applying desired would not change the live object. The apply is dry-run on the
+// server and its result compared with the live object, so defaulted values don't count as changes while fields
+// dropped from the manifest, which the apply removes, do. A failed dry-run is a change, the apply reports the error.
+func isUnchanged(ctx context.Context, dri dynamic.ResourceInterface, desired, live *unstructured.Unstructured) bool {
+	applied, err := dryRunObject(ctx, dri, desired)
+	if err != nil {
+		log.Debugf("unable to dry-run %s/%s to compare it with the live object: %v", desired.GetKind(), desired.GetName(), err)
+		return false
+	}
+	return reflect.DeepEqual(withoutServerManagedMetadata(applied), withoutServerManagedMetadata(live))
+}
+
+// withoutServerManagedMetadata returns a copy of the object without the serverManagedMetadata.
+func withoutServerManagedMetadata(obj *unstructured.Unstructured) map[string]interface{} {
+	obj = obj.DeepCopy()
+	for _, field := range serverManagedMetadata {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	return obj.Object
+}
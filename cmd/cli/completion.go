@@ -5,23 +5,36 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"os"
+	"regexp"
 	"strings"
 	"time"
 
 	openai "github.com/sashabaranov/go-openai"
 	"github.com/sethvargo/go-retry"
+	log "github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
 	"golang.org/x/exp/slices"
+	"sigs.k8s.io/yaml"
 )
 
 //define a struct having a field of type openai.Client
 type oaiClients struct {
 	openAIClient openai.Client
+	//the Responses API isn't supported by the openai package, it's called with a plain HTTP client, see responses.go
+	baseURL    string
+	httpClient *http.Client
 }
 
 // newOAIClients creates and returns a new instance of the oaiClients struct,
 // which contains the OpenAI clients used for making API calls.
 //you can get the open ai client directly or open ai via azure
 func newOAIClients() (oaiClients, error) {
+	//a typo in the endpoint would otherwise only show up as a connection error of the first request
+	if err := validateEndpointURL(*openAIEndpoint); err != nil {
+		return oaiClients{}, err
+	}
 	//create a variable config of type openai.ClientConfig
 	var config openai.ClientConfig
 	//set config equal to openAIAPIKey which will be set in the environment variables
@@ -30,48 +43,300 @@ func newOAIClients() (oaiClients, error) {
 	config = openai.DefaultConfig(*openAIAPIKey)
 //openAIEndpoint is a variable defined in the root.go file, we're checking here
 //if and another variable defined (openaiAPIURLv1) in root.go are same or not
-	if openAIEndpoint != &openaiAPIURLv1 {
+	if *openAIEndpoint != openaiAPIURLv1 {
 		//we enter this loop if both the links are not equal, in many cases you might
 		//not even specify the endpoint and it'll go with APIURLv1 defined by default
 		// so if they're not equal, we're checking if it has azure open ai URL
-		if strings.Contains(*openAIEndpoint, "openai.azure.com") {
+		if getProvider() == providerAzure {
 			//if it is the open ai API via azure, we set it using DefaultAzureConfig function
 			//present in the open ai package
 			config = openai.DefaultAzureConfig(*openAIAPIKey, *openAIEndpoint)
+			//the mappings from the azure-openai-map-file merged with the inline azure-openai-map
+			modelMap, err := azureModelMapping()
+			if err != nil {
+				return oaiClients{}, err
+			}
 //if we have set the azureModelMap (in root.go file) and length is not zero
-			if len(*azureModelMap) != 0 {
+			if len(modelMap) != 0 {
 //then we assign that value to open ai config that needs to work with it
 //this is basically mapping for open ai to azure
 				config.AzureModelMapperFunc = func(model string) string {
-					return (*azureModelMap)[model]
+					return modelMap[model]
 				}
 			}
 		} else {
 // if we're not using open ai via azure, we will assign the AIEndpoint to BaseURL 
-			config.BaseURL = *openAIEndpoint
+			//the client appends paths like /chat/completions to the BaseURL, so it has to end with the
+			//API version exactly once, whether or not it was given with the endpoint
+			baseURL, err := normalizeEndpoint(*openAIEndpoint)
+			if err != nil {
+				return oaiClients{}, err
+			}
+			log.Debugf("using base URL %s", baseURL)
+			config.BaseURL = baseURL
 		}
 		//still crafting the config object, by specifying an API version
 		// use 2023-07-01-preview api version for function calls
 		config.APIVersion = "2023-07-01-preview"
 	}
+	//our API gateway rate limits and logs requests by User-Agent, see useragent.go
+	var transport http.RoundTripper = userAgentTransport{next: http.DefaultTransport}
+	//batch-requests-per-minute counts every request, the function calls, continuations and retries too
+	if requestLimiter != nil {
+		transport = rateLimitTransport{limiter: requestLimiter, next: transport}
+	}
+	config.HTTPClient = &http.Client{Transport: transport}
 //passing the crafted config object to the NewClientWithConfig func. from open ai
 //and assigning it to the openAIClient field in oaiClients - a struct defined at the top of this file
 	clients := oaiClients{
 		openAIClient: *openai.NewClientWithConfig(config),
+		baseURL:      config.BaseURL,
+		httpClient:   config.HTTPClient,
+	}
+	if *validateEndpoint {
+		if err := checkEndpointReachable(clients); err != nil {
+			return oaiClients{}, err
+		}
 	}
 	return clients, nil
 }
 
+// endpointCheckTimeout bounds the request of the validate-endpoint flag
+const endpointCheckTimeout = 10 * time.Second
+
+// validateEndpointURL returns an error pointing at the openai-endpoint flag if the endpoint isn't an http or https URL.
+func validateEndpointURL(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid --openai-endpoint %q: %w", endpoint, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid --openai-endpoint %q, expected an http or https URL like %s", endpoint, openaiAPIURLv1)
+	}
+	return nil
+}
+
+// checkEndpointReachable lists the models of the endpoint, the cheapest request there is. Endpoints which answer
+// with an error other than a rejected key are reachable, e.g. gateways which don't serve the models.
+func checkEndpointReachable(clients oaiClients) error {
+	ctx, cancel := context.WithTimeout(context.Background(), endpointCheckTimeout)
+	defer cancel()
+	_, err := clients.openAIClient.ListModels(ctx)
+	if err == nil {
+		return nil
+	}
+	status := errorStatusCode(err)
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return fmt.Errorf("--openai-endpoint %s rejected the OpenAI key: %w", *openAIEndpoint, err)
+	case status != 0:
+		log.Debugf("listing the models of %s failed, but the endpoint is reachable: %v", *openAIEndpoint, err)
+		return nil
+	default:
+		return fmt.Errorf("--openai-endpoint %s is not reachable: %w", *openAIEndpoint, err)
+	}
+}
+
+// azureModelMapping returns the mapping from OpenAI model to Azure OpenAI deployment. The mappings
+// are read from the YAML or JSON azure-openai-map-file, mappings of the inline azure-openai-map take precedence.
+func azureModelMapping() (map[string]string, error) {
+	modelMap := map[string]string{}
+	if *azureModelMapFile != "" {
+		content, err := os.ReadFile(*azureModelMapFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read azure openai map file: %w", err)
+		}
+		//YAML is a superset of JSON, so this parses both
+		if err := yaml.Unmarshal(content, &modelMap); err != nil {
+			return nil, fmt.Errorf("unable to parse azure openai map file %s: %w", *azureModelMapFile, err)
+		}
+	}
+	for model, deployment := range *azureModelMap {
+		modelMap[model] = deployment
+	}
+	return modelMap, nil
+}
+
+// maxRetries is how often a rate limited request is retried
+const maxRetries = 10
+
+// retryableError decides whether a failed request is retried. By default that's rate limited requests,
+// the retry-on-status and retry-on-error flags configure it for gateways which answer differently.
+var retryableError = isRetryableError
+
+// errorStatusCode returns the HTTP status code of an error of the OpenAI client, 0 if there is none,
+// e.g. because the endpoint couldn't be reached.
+func errorStatusCode(err error) int {
+	//the client returns an APIError if the error response has a JSON body and a RequestError otherwise
+	apiErr := &openai.APIError{}
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode
+	}
+	requestErr := &openai.RequestError{}
+	if errors.As(err, &requestErr) {
+		return requestErr.HTTPStatusCode
+	}
+	return 0
+}
+
+// isRetryableError reports whether the status code of the error is one of the retry-on-status flag,
+// or the error message contains one of the retry-on-error flag, case-insensitively.
+func isRetryableError(err error) bool {
+	if status := errorStatusCode(err); status != 0 && slices.Contains(*retryOnStatus, status) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range *retryOnError {
+		if s != "" && strings.Contains(msg, strings.ToLower(s)) {
+			return true
+		}
+	}
+	return false
+}
+
+// providers we know how to talk to, the provider is set with the provider flag or derived from the configured endpoint
+const (
+	providerOpenAI = "openai"
+	providerAzure  = "azure"
+	providerLocal  = "local"
+)
+
+// getProvider returns the provider for the configured OpenAI endpoint.
+// Endpoints which are neither OpenAI nor Azure OpenAI are treated as OpenAI-compatible local endpoints, like Local AI.
+func getProvider() string {
+	switch {
+	case *provider != "":
+		return *provider
+	case *openAIEndpoint == openaiAPIURLv1:
+		return providerOpenAI
+	case strings.Contains(*openAIEndpoint, "openai.azure.com"):
+		return providerAzure
+	default:
+		return providerLocal
+	}
+}
+
+// resolveDeploymentName selects the deployment name of the active provider from the deployment-name-per-provider
+// flag, so switching between providers doesn't need a different openai-deployment-name every time.
+// An openai-deployment-name given on the command line always wins.
+func resolveDeploymentName() {
+	if flag.CommandLine.Changed("openai-deployment-name") {
+		return
+	}
+	if name, ok := (*deploymentNamePerProvider)[getProvider()]; ok {
+		log.Debugf("using deployment name %s of provider %s", name, getProvider())
+		*openAIDeploymentName = name
+	}
+}
+
+// modelsWithoutTemperature are the prefixes of reasoning models which reject any temperature but their default
+var modelsWithoutTemperature = []string{"o1", "o3", "o4", "gpt-5"}
+
+// acceptsTemperature reports whether the model accepts the temperature parameter, requests for models
+// which don't leave it out instead of failing with an API error.
+func acceptsTemperature(deploymentName string) bool {
+	for _, prefix := range modelsWithoutTemperature {
+		if deploymentName == prefix || strings.HasPrefix(deploymentName, prefix+"-") {
+			return false
+		}
+	}
+	return true
+}
+
+// getMaxTemperature returns the highest temperature the provider accepts.
+// OpenAI and Azure OpenAI accept 0 to 2, for other endpoints we stay within 0 to 1 which all of them accept.
+func getMaxTemperature(provider string) float64 {
+	if provider == providerOpenAI || provider == providerAzure {
+		return 2
+	}
+	return 1
+}
+
+// apiVersionSegment matches a path segment of an endpoint which is an API version, e.g. v1 or v1beta
+var apiVersionSegment = regexp.MustCompile(`^v[0-9]+[a-z0-9]*$`)
+
+// normalizeEndpoint normalizes the path of an OpenAI-compatible endpoint. An endpoint without a path gets
+// the API version, e.g. http://localhost:8080 becomes http://localhost:8080/v1, a duplicated version like
+// https://gw.example.com/v1/v1 becomes https://gw.example.com/v1. Other paths are kept as they are, gateways
+// like https://gw.example.com/v1beta/openai or https://gw.example.com/openai/deployments/gpt-4o serve the API there.
+func normalizeEndpoint(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid --openai-endpoint %q: %w", endpoint, err)
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) == 1 && segments[0] == "" {
+		segments = nil
+	}
+	//drop repeated version segments at the end, e.g. /v1/v1
+	for len(segments) > 1 && segments[len(segments)-1] == segments[len(segments)-2] && apiVersionSegment.MatchString(segments[len(segments)-1]) {
+		segments = segments[:len(segments)-1]
+	}
+	//only an endpoint without a path is missing the version
+	if len(segments) == 0 {
+		segments = []string{"v1"}
+	}
+
+	u.Path = "/" + strings.Join(segments, "/")
+	return u.String(), nil
+}
+
 // getNonChatModels returns a slice of non-chat models.
 func getNonChatModels() []string {
 	// Return a slice containing the names of non-chat models.
 	return []string{"code-davinci-002", "text-davinci-003"}
 }
 
+// values of the completion-api flag
+const (
+	completionAPIAuto        = "auto"
+	completionAPIChat        = "chat"
+	completionAPICompletions = "completions"
+	completionAPIResponses   = "responses"
+)
+
+// useCompletionsAPI reports whether the legacy completions API is used for the model instead of chat completions.
+// The completion-api flag forces the choice, with auto we decide based on the list of known non-chat models.
+func useCompletionsAPI(deploymentName string) bool {
+	switch *completionAPI {
+	case completionAPIChat:
+		return false
+	case completionAPICompletions:
+		return true
+	default:
+		return slices.Contains(getNonChatModels(), deploymentName)
+	}
+}
+
+// resolveCompletionAPI applies the force-chat flag, an alias of --completion-api=chat. OpenAI has deprecated
+// the legacy models together with the completions API, so sending them to the completions API is only done with
+// a warning. The chat completion API never served them, asking for it fails right away with a clear error
+// instead of the error of the client.
+func resolveCompletionAPI() error {
+	if *forceChat {
+		if *completionAPI != completionAPIAuto && *completionAPI != completionAPIChat {
+			return fmt.Errorf("--force-chat can't be combined with --completion-api=%s", *completionAPI)
+		}
+		*completionAPI = completionAPIChat
+	}
+	if !slices.Contains(getNonChatModels(), *openAIDeploymentName) {
+		return nil
+	}
+	if *completionAPI == completionAPIChat {
+		return fmt.Errorf("%s is a deprecated completions model which the chat completion API doesn't serve, use a chat model like gpt-4o-mini with --openai-deployment-name", *openAIDeploymentName)
+	}
+	if useCompletionsAPI(*openAIDeploymentName) {
+		log.Warnf("%s is deprecated and only available through the completions API, which OpenAI is removing, use a chat model instead", *openAIDeploymentName)
+	}
+	return nil
+}
+
 // gptCompletion generates completions for a given prompt using the OpenAI GPT model.
 // It takes a context, a client, a list of prompts, and a deployment name as input.
-// It returns the generated completion string and an error if any.
-func gptCompletion(ctx context.Context, client oaiClients, prompts []string, deploymentName string) (string, error) {
+// It returns the generated completion string, which model generated it and an error if any.
+// onRetry is called before every retry with the delay until the retry and the number of the attempt.
+func gptCompletion(ctx context.Context, client oaiClients, prompts []string, deploymentName string, onRetry func(delay time.Duration, attempt int)) (completion string, info completionInfo, err error) {
 	temp := float32(*temperature)
 //we are going to create a prompt and going to append things to it and this is why
 //we set it to be strings.Builder instead of just strings
@@ -87,42 +352,182 @@ func gptCompletion(ctx context.Context, client oaiClients, prompts []string, dep
 		fmt.Fprintf(&prompt, "You are a Kubernetes YAML generator, only generate valid Kubernetes YAML manifests. Do not provide any explanations, only generate YAML. ")
 	}
 
+	//provider specific annotations for load balancers and ingresses, see cloud.go
+	if guidance, ok := cloudGuidance[*cloud]; ok {
+		fmt.Fprintf(&prompt, "%s", guidance)
+	}
+
+	//HTTPS ingresses get their certificates from cert-manager, the annotation tells it which issuer to use
+	if *ingressTLS != "" {
+		fmt.Fprintf(&prompt, "For every Ingress add the annotation cert-manager.io/cluster-issuer: %s and a tls section listing all hosts of its rules with the secretName <ingress name>-tls, so cert-manager issues the certificate. ", *ingressTLS)
+	}
+
+	//production ready deployments come with companion resources which are applied in the same manifest
+	if *production {
+		fmt.Fprintf(&prompt, "For every Deployment also generate a policy/v1 PodDisruptionBudget with minAvailable: 1 and an autoscaling/v2 HorizontalPodAutoscaler targeting 70%% CPU utilization, both selecting the Deployment, as separate YAML documents in the same namespace. Make sure the Deployment has CPU requests so the HorizontalPodAutoscaler works. ")
+	}
+
+	//highly available workloads spread their pods, addHighAvailability checks it after generation, see ha.go
+	if *ha {
+		prompt.WriteString(haPrompt)
+	}
+
+	//the model only references files of the from-file flag, their content is embedded after generation
+	if sources, err := parseFileSources(*fromFile); err == nil && len(sources) > 0 {
+		fmt.Fprintf(&prompt, "%s", fromFilePrompt(sources))
+	}
+
+	//the template is the skeleton of the manifest, see templates.go
+	if manifestSkeleton != "" {
+		prompt.WriteString(templatePrompt())
+	}
+
+	//vision models generate the manifest from the images of the image flag, see image.go
+	if len(*images) > 0 {
+		fmt.Fprintf(&prompt, "%s", imagePrompt)
+	}
+
+	//a chart is a set of files instead of a manifest, see helm.go
+	if *formatFlag == formatHelmChart {
+		fmt.Fprintf(&prompt, "%s", helmChartPrompt)
+	}
+
+	//with the clarify flag the model may ask a question instead of guessing on vague prompts,
+	//the question is detected by the prefix in the run function
+	if *clarify {
+		fmt.Fprintf(&prompt, "If the request is too vague to generate a correct manifest, instead reply with a single short clarifying question starting with %s and nothing else. ", clarifyingQuestionPrefix)
+	}
+
+	//the instructions above are repeated for every part when a prompt is too large to send at once,
+	//the user's request is what gets split
+	instructions := prompt.String()
+	//the request in order, and split into its context and the user's text, see requestContext
+	var request, requestCtx, userText strings.Builder
 	//range over the prompts slice received in the function, access each prompt
-	//using the 'p' variable and append it to the prompt defined above which is a strings.Builder
-	//and has either of the values defined above
+	//using the 'p' variable and append it to the request which is a strings.Builder
 	for _, p := range prompts {
-		// Append each prompt to the prompt builder.
-		fmt.Fprintf(&prompt, "%s", p)
+		// Append each prompt to the request builder.
+		fmt.Fprintf(&request, "%s", p)
+		if requestContext(p) {
+			requestCtx.WriteString(p)
+		} else {
+			userText.WriteString(p)
+		}
+	}
+
+	//the constraints the user wants after every request, e.g. use the latest stable apiVersions
+	if *promptSuffix != "" {
+		fmt.Fprintf(&request, " %s", strings.TrimSpace(*promptSuffix))
+		fmt.Fprintf(&userText, " %s", strings.TrimSpace(*promptSuffix))
+	}
+
+	//identical requests are answered from the response cache with the cache flag, see cache.go
+	key := responseCacheKey(instructions, request.String()+imagesDigest(), deploymentName, temp)
+	if completion, info, ok := readResponseCache(key); ok {
+		return completion, info, nil
+	}
+	defer func() {
+		if err == nil {
+			writeResponseCache(key, completion, info)
+		}
+	}()
+
+	//big infrastructure descriptions can overflow the context window of the model, instead of
+	//failing with a context length error we generate the manifest part by part, see chunk.go
+	limit := promptTokenLimit(deploymentName)
+	if limit == 0 || estimateTokens(instructions+request.String()) <= limit {
+		prompt.WriteString(request.String())
+		return completeWithRetry(ctx, client, &prompt, temp, deploymentName, onRetry)
+	}
+	//only the user's text is split, the instructions and the context go with every part
+	budget := limit - estimateTokens(instructions+requestCtx.String()) - partInstructionTokens
+	if budget <= 0 {
+		return "", completionInfo{}, fmt.Errorf("the instructions and context of the prompt alone exceed the limit of %d tokens of %s, use fewer --context-resources or a model with a larger context window", limit, deploymentName)
+	}
+	parts := splitPrompt(userText.String(), budget)
+	if len(parts) == 0 {
+		return "", completionInfo{}, fmt.Errorf("the context of the prompt alone exceeds the limit of %d tokens of %s, use fewer --context-resources or a model with a larger context window", limit, deploymentName)
 	}
+	log.Infof("the prompt is too large for %s, generating the manifest in %d parts", deploymentName, len(parts))
+
+	var documents []string
+	for i, part := range parts {
+		var partPrompt strings.Builder
+		partPrompt.WriteString(instructions)
+		partPrompt.WriteString(requestCtx.String())
+		fmt.Fprintf(&partPrompt, partInstruction, i+1, len(parts))
+		partPrompt.WriteString(part)
+		resp, partInfo, err := completeWithRetry(ctx, client, &partPrompt, temp, deploymentName, onRetry)
+		if err != nil {
+			return "", completionInfo{}, fmt.Errorf("generating part %d of %d: %w", i+1, len(parts), err)
+		}
+		//every part is a generation of its own, the usage adds up
+		partInfo.PromptTokens += info.PromptTokens
+		partInfo.CompletionTokens += info.CompletionTokens
+		info = partInfo
+		if resp = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(resp), "---")); resp != "" {
+			documents = append(documents, resp)
+		}
+		//no need to generate the other parts if the manifest is already too large
+		if err := checkManifestSize(strings.Join(documents, "\n---\n")); err != nil {
+			return "", completionInfo{}, err
+		}
+	}
+	return strings.Join(documents, "\n---\n") + "\n", info, nil
+}
+
+// completeWithRetry sends the prompt to the chat or completions API of the model and retries rate limited requests.
+// onRetry is called before every retry with the delay until the retry and the number of the attempt.
+func completeWithRetry(ctx context.Context, client oaiClients, prompt *strings.Builder, temp float32, deploymentName string, onRetry func(delay time.Duration, attempt int)) (string, completionInfo, error) {
 //define a variable resp for working with response object
 	var resp string
+	var info completionInfo
 	var err error
+	//offline runs only use cached responses, see offline.go
+	if *noNetwork {
+		return "", completionInfo{}, errNoNetwork
+	}
 	//setting the max retires at 10 and then later also handling too many retries condition
-	r := retry.WithMaxRetries(10, retry.NewExponential(1*time.Second))
-	if err := retry.Do(ctx, r, func(ctx context.Context) error {
-		if slices.Contains(getNonChatModels(), deploymentName) {
+	r := retry.WithMaxRetries(maxRetries, retry.NewExponential(1*time.Second))
+	//under sustained rate limiting 10 exponential retries can take minutes,
+	//retry-max-duration bounds the total time spent retrying
+	if *retryMaxDuration > 0 {
+		r = retry.WithMaxDuration(*retryMaxDuration, r)
+	}
+	//Next is only called by retry.Do for retryable errors, so wrapping the backoff
+	//tells us when we are backing off and for how long
+	var attempt int
+	//the chat and completions requests leave out a zero temperature, see acceptsTemperature
+	if !acceptsTemperature(deploymentName) {
+		temp = 0
+	}
+	b := retry.BackoffFunc(func() (time.Duration, bool) {
+		delay, stop := r.Next()
+		if !stop && onRetry != nil {
+			attempt++
+			onRetry(delay, attempt)
+		}
+		return delay, stop
+	})
+	if err := retry.Do(ctx, b, func(ctx context.Context) error {
+		if *completionAPI == completionAPIResponses {
+			//the newer Responses API, for models which are only available through it
+			resp, info, err = client.openaiResponsesCompletion(ctx, prompt, temp, deploymentName)
+		} else if useCompletionsAPI(deploymentName) {
 			// Use the OpenAI GPT completion method for non-chat models.
 			//open ai GPT completion function is used, notice the missing 'chat'
-			resp, err = client.openaiGptCompletion(ctx, &prompt, temp)
+			resp, info, err = client.openaiGptCompletion(ctx, prompt, temp)
 		} else {
 			// Use the OpenAI GPT chat completion method for chat models.
 			//if the slice doesn't contain non chat models, then we call this
-			resp, err = client.openaiGptChatCompletion(ctx, &prompt, temp)
-		}
-//if there are any errors when making a request to the open ai API, they're accessible to us
-//through openai.RequestError and we assign it to the requestErr variable
-		requestErr := &openai.RequestError{}
-		//err is the error from calling open ai, from the resp lines above
-		//errors.As helps us compare the err and requestErr, whether they're the same
-		if errors.As(err, &requestErr) {
-		//so if we have an error which is of type openai Request error, means we have some 
-		//issue while making a request, now we want to zero down on the issue, so we check if it
-		//has the status code of too many requests,which is 429 code
-			if requestErr.HTTPStatusCode == http.StatusTooManyRequests {
-		//if this is the case, it means the issue is retryable and we can rety
-		//the request after a certain delay
-				return retry.RetryableError(err)
-			}
+			resp, info, err = client.openaiGptChatCompletion(ctx, prompt, temp)
+		}
+//whether an error is worth retrying, e.g. rate limiting, is decided by retryableError
+		//which gateways with other status codes can configure
+		if err != nil && retryableError(err) {
+			//if this is the case, it means the issue is retryable and we can rety
+			//the request after a certain delay
+			return retry.RetryableError(err)
 		}
 		//if the error hasn't matched the condition above of being a request retry error
 		//and it still exists, means it's something else and is not retryable, so we will simply
@@ -134,9 +539,9 @@ func gptCompletion(ctx context.Context, client oaiClients, prompts []string, dep
 		return nil
 	}); err != nil {
 		//handling the error from the retry code block
-		return "", err
+		return "", completionInfo{}, err
 	}
 
 	// Return the generated completion string.
-	return resp, nil
+	return resp, info, nil
 }
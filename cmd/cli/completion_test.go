@@ -0,0 +1,31 @@
+package cli
+
+import "testing"
+
+func TestNormalizeEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{endpoint: "http://localhost:8080", want: "http://localhost:8080/v1"},
+		{endpoint: "http://localhost:8080/", want: "http://localhost:8080/v1"},
+		{endpoint: "https://api.openai.com/v1", want: "https://api.openai.com/v1"},
+		{endpoint: "https://gw.example.com/openai/v1/", want: "https://gw.example.com/openai/v1"},
+		{endpoint: "https://gw.example.com/v1/v1", want: "https://gw.example.com/v1"},
+		{endpoint: "https://gw.example.com/openai", want: "https://gw.example.com/openai"},
+		{endpoint: "https://generativelanguage.googleapis.com/v1beta/openai", want: "https://generativelanguage.googleapis.com/v1beta/openai"},
+		{endpoint: "https://gw.example.com/openai/deployments/gpt-4o", want: "https://gw.example.com/openai/deployments/gpt-4o"},
+		{endpoint: "https://api.groq.com/openai/v1", want: "https://api.groq.com/openai/v1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			got, err := normalizeEndpoint(tt.endpoint)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeEndpoint(%q) = %q, want %q", tt.endpoint, got, tt.want)
+			}
+		})
+	}
+}
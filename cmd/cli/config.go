@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+// configFile returns the path of the config file in the user's config directory.
+// It holds default values for flags, keyed by flag name, e.g. openai-deployment-name: gpt-4o
+func configFile() (string, error) {
+	return stateFile("config.yaml")
+}
+
+// readConfig returns the values of the config file, or nil if there is none.
+func readConfig() (map[string]string, error) {
+	path, err := configFile()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]string
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("unable to parse config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// loadConfig sets the flags to the values of the config file. It runs after the arguments are parsed and only
+// sets the flags which weren't given on the command line, values from environment variables are kept too.
+func loadConfig() error {
+	values, err := readConfig()
+	if err != nil {
+		return err
+	}
+	for name, value := range values {
+		f := flag.CommandLine.Lookup(name)
+		if f == nil {
+			log.Warnf("ignoring unknown flag %s in the config file", name)
+			continue
+		}
+		if _, ok := os.LookupEnv(flagEnvName(name)); ok || f.Changed {
+			continue
+		}
+		//Set appends to slice flags which were set before, the config value replaces the default
+		if slice, ok := f.Value.(flag.SliceValue); ok {
+			items, err := readAsCSV(value)
+			if err != nil {
+				return fmt.Errorf("invalid value %q for %s in the config file: %w", value, name, err)
+			}
+			if err := slice.Replace(items); err != nil {
+				return fmt.Errorf("invalid value %q for %s in the config file: %w", value, name, err)
+			}
+			continue
+		}
+		if err := f.Value.Set(value); err != nil {
+			return fmt.Errorf("invalid value %q for %s in the config file: %w", value, name, err)
+		}
+	}
+	return nil
+}
+
+// readAsCSV splits the value of a slice flag like pflag does, e.g. a,"b,c" is a and b,c.
+func readAsCSV(value string) ([]string, error) {
+	if value == "" {
+		return []string{}, nil
+	}
+	return csv.NewReader(strings.NewReader(value)).Read()
+}
+
+// writeConfig merges the values into the config file. The file may contain the OpenAI key,
+// so only the user can read it.
+func writeConfig(values map[string]string) (string, error) {
+	path, err := configFile()
+	if err != nil {
+		return "", err
+	}
+	existing, err := readConfig()
+	if err != nil {
+		return "", err
+	}
+	if existing == nil {
+		existing = map[string]string{}
+	}
+	for k, v := range values {
+		existing[k] = v
+	}
+	data, err := yaml.Marshal(existing)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, data, 0o600)
+}
+
+// flagEnvName returns the environment variable of a flag, e.g. OPENAI_API_KEY for openai-api-key.
+func flagEnvName(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/sethvargo/go-retry"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	runtimeschema "k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// crdResource is the resource of CustomResourceDefinitions, we use the dynamic client
+// so we don't need the apiextensions clientset just to read their schemas
+var crdResource = runtimeschema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// crdDefinitions returns the OpenAPI schemas of the custom resources installed in the cluster, named like
+// the API server names them in /openapi/v2, e.g. io.cert-manager.v1.Certificate for the v1 Certificate of
+// the cert-manager.io group. Like the definitions of built-in resources they list the group version kind
+// they describe in x-kubernetes-group-version-kind.
+func crdDefinitions(ctx context.Context) (map[string]interface{}, error) {
+	config, err := getRestConfig(getKubeConfig())
+	if err != nil {
+		return nil, err
+	}
+	dd, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	crds, err := dd.Resource(crdResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	definitions := map[string]interface{}{}
+	for _, crd := range crds.Items {
+		group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+		kind, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "kind")
+		versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+		for _, v := range versions {
+			version, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _, _ := unstructured.NestedString(version, "name")
+			schema, found, _ := unstructured.NestedMap(version, "schema", "openAPIV3Schema")
+			if !found {
+				continue
+			}
+			schema["x-kubernetes-group-version-kind"] = []interface{}{
+				map[string]interface{}{"group": group, "version": name, "kind": kind},
+			}
+			definitions[crdDefinitionName(group, name, kind)] = schema
+		}
+	}
+	return definitions, nil
+}
+
+// crdEstablishRetries is how often we retry when the custom resources of a CRD applied in the same manifest
+// aren't served yet, discovery can lag behind a CRD for a moment even after it reports Established
+const crdEstablishRetries = 5
+
+// crdEstablishBackoff is the backoff between the retries of crdEstablishRetries, starting at half a second
+// the retries take about 15 seconds at most
+func crdEstablishBackoff() retry.Backoff {
+	return retry.WithMaxRetries(crdEstablishRetries, retry.NewExponential(500*time.Millisecond))
+}
+
+// manifestCRDKinds returns the group kinds of the custom resources the CRDs of the manifest define.
+func manifestCRDKinds(objects []*unstructured.Unstructured) map[runtimeschema.GroupKind]bool {
+	kinds := map[runtimeschema.GroupKind]bool{}
+	for _, obj := range objects {
+		if obj.GroupVersionKind().GroupKind() != (runtimeschema.GroupKind{Group: crdResource.Group, Kind: "CustomResourceDefinition"}) {
+			continue
+		}
+		group, _, _ := unstructured.NestedString(obj.Object, "spec", "group")
+		kind, _, _ := unstructured.NestedString(obj.Object, "spec", "names", "kind")
+		kinds[runtimeschema.GroupKind{Group: group, Kind: kind}] = true
+	}
+	return kinds
+}
+
+// crdDefinitionName returns the definition name of a custom resource, the group is reversed like
+// a Java package name, e.g. stable.example.com, v1 and CronTab give com.example.stable.v1.CronTab.
+func crdDefinitionName(group, version, kind string) string {
+	parts := strings.Split(group, ".")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(append(parts, version, kind), ".")
+}
+
+// addCRDDefinitions adds the schemas of the installed custom resources which are missing from the
+// definitions, e.g. because the schema is a static spec from k8s-openapi-url. A cluster we can't
+// reach or list CRDs in only leaves the definitions as they are.
+func addCRDDefinitions(definitions map[string]interface{}) {
+	crds, err := crdDefinitions(context.Background())
+	if err != nil {
+		log.Debugf("unable to fetch CRD schemas: %v", err)
+		return
+	}
+	for name, def := range crds {
+		if _, ok := definitions[name]; !ok {
+			definitions[name] = def
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	runtimeschema "k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"sigs.k8s.io/yaml"
+)
+
+// maxDescribeEvents is how many of the most recent events of the object are added to the prompt
+const maxDescribeEvents = 20
+
+// describeInstructions asks the model for an explanation instead of a manifest
+const describeInstructions = "You are a Kubernetes expert. Explain the current state of the following Kubernetes object to a human in a few short paragraphs of plain text, don't generate YAML. Summarize what it does, whether it is healthy according to its status, conditions and events, and point out potential issues like failing conditions, restarts, missing resource requests or limits, missing probes or a single replica, each with a short suggestion how to fix it. "
+
+// redactedValue replaces the values of secrets before they are sent to the model
+const redactedValue = "REDACTED"
+
+// describeCmd returns the describe subcommand, which explains the current state of an object.
+func describeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "describe TYPE NAME | TYPE/NAME",
+		Short: "Explain the current state of an object",
+		Long:  "Explain the current state of an object in the cluster, e.g. describe deployment api. The object and its recent events are fetched from the cluster and the model explains its state and potential issues.",
+		Args:  cobra.RangeArgs(1, 2),
+		PreRunE: func(_ *cobra.Command, _ []string) error {
+			return requireAPIKey()
+		},
+		RunE: func(_ *cobra.Command, args []string) error {
+			resource, name, ok := strings.Cut(args[0], "/")
+			if len(args) == 2 && !ok {
+				resource, name = args[0], args[1]
+			} else if len(args) == 2 || !ok || name == "" {
+				return fmt.Errorf("expected TYPE NAME or TYPE/NAME")
+			}
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer cancel()
+			resolveDeploymentName()
+			return describeObject(ctx, resource, name)
+		},
+	}
+}
+
+// describeObject fetches the object and has the model explain it. The resource is anything kubectl get
+// understands, e.g. deployment, deploy, deployments.apps or a custom resource.
+func describeObject(ctx context.Context, resource, name string) error {
+	info, err := objectInfo(ctx, resource, name)
+	if err != nil {
+		return err
+	}
+
+	client, err := newOAIClients()
+	if err != nil {
+		return err
+	}
+	var prompt strings.Builder
+	prompt.WriteString(describeInstructions)
+	prompt.WriteString(info)
+	explanation, _, err := completeWithRetry(ctx, client, &prompt, float32(*temperature), *openAIDeploymentName, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Println(strings.TrimSpace(explanation))
+	return nil
+}
+
+// objectInfo returns the object as YAML, without managed fields and with the values of secrets redacted,
+// followed by its most recent events.
+func objectInfo(ctx context.Context, resource, name string) (string, error) {
+	config, err := getRestConfig(getKubeConfig())
+	if err != nil {
+		return "", err
+	}
+	c, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return "", err
+	}
+	obj, mapping, namespace, err := liveObject(ctx, config, resource, name)
+	if err != nil {
+		return "", err
+	}
+	ref := objectRef(mapping, obj)
+
+	//managed fields are noise for the model and the values of secrets must not leave the cluster
+	obj.SetManagedFields(nil)
+	redactSecret(obj)
+	objYAML, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return "", err
+	}
+
+	selector := []fields.Selector{
+		fields.OneTermEqualSelector("involvedObject.kind", obj.GetKind()),
+		fields.OneTermEqualSelector("involvedObject.name", name),
+	}
+	events, err := c.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{FieldSelector: fields.AndSelectors(selector...).String()})
+	if err != nil {
+		return "", fmt.Errorf("unable to list events of %s: %w", ref, err)
+	}
+
+	var out strings.Builder
+	if namespace != "" {
+		fmt.Fprintf(&out, "\n%s in namespace %s:\n%s\nRecent events:\n", ref, namespace, objYAML)
+	} else {
+		fmt.Fprintf(&out, "\n%s:\n%s\nRecent events:\n", ref, objYAML)
+	}
+	items := recentEvents(events.Items, maxDescribeEvents)
+	for _, e := range items {
+		fmt.Fprintf(&out, "%s %s %s: %s (x%d)\n", eventTime(e), e.Type, e.Reason, e.Message, e.Count)
+	}
+	if len(items) == 0 {
+		out.WriteString("none\n")
+	}
+	return out.String(), nil
+}
+
+// liveObject gets an object from the cluster, the resource is anything kubectl get understands, e.g. deployment,
+// deploy, deployments.apps or a custom resource. Namespaced objects are taken from the namespace of the namespace
+// flag or the kubeconfig, it is returned with the object and is empty for cluster-scoped objects.
+func liveObject(ctx context.Context, config *rest.Config, resource, name string) (*unstructured.Unstructured, *meta.RESTMapping, string, error) {
+	c, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	dd, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	gr, err := restmapper.GetAPIGroupResources(c.Discovery())
+	if err != nil {
+		return nil, nil, "", err
+	}
+	//the shortcut expander knows the short names like deploy
+	mapper := restmapper.NewShortcutExpander(restmapper.NewDiscoveryRESTMapper(gr), c.Discovery())
+	gvk, err := mapper.KindFor(runtimeschema.ParseGroupResource(resource).WithVersion(""))
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("unknown resource type %q: %w", resource, err)
+	}
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	var dri dynamic.ResourceInterface = dd.Resource(mapping.Resource)
+	namespace := ""
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if namespace, err = getNamespace(getKubeConfig()); err != nil {
+			return nil, nil, "", err
+		}
+		dri = dd.Resource(mapping.Resource).Namespace(namespace)
+	}
+	obj, err := dri.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("unable to get %s %s: %w", resource, name, err)
+	}
+	return obj, mapping, namespace, nil
+}
+
+// redactSecret replaces the values of a Secret with redactedValue, the keys are kept. Other kinds are left as they are.
+func redactSecret(obj *unstructured.Unstructured) {
+	if obj.GetKind() != "Secret" {
+		return
+	}
+	for _, field := range []string{"data", "stringData"} {
+		values, _, _ := unstructured.NestedMap(obj.Object, field)
+		for key := range values {
+			values[key] = redactedValue
+		}
+		if len(values) > 0 {
+			_ = unstructured.SetNestedMap(obj.Object, values, field)
+		}
+	}
+	//kubectl apply keeps the last applied Secret, values included, in an annotation
+	annotations := obj.GetAnnotations()
+	if _, ok := annotations["kubectl.kubernetes.io/last-applied-configuration"]; ok {
+		annotations["kubectl.kubernetes.io/last-applied-configuration"] = redactedValue
+		obj.SetAnnotations(annotations)
+	}
+}
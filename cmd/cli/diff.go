@@ -0,0 +1,409 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// diffContext is the number of unchanged lines shown around a change
+const diffContext = 3
+
+// diffManifest returns a unified diff between the live objects in the cluster and the objects as the API server
+// would store them after the apply, the result of a server-side dry-run apply, so fields the server defaults
+// don't show up as removed. Objects which don't exist yet are shown as completely added. The diff is empty if
+// applying the manifest wouldn't change anything. Server managed fields and the diff-ignore fields are left out.
+func diffManifest(ctx context.Context, completion string, annotations map[string]string) (string, error) {
+	config, err := getRestConfig(getKubeConfig())
+	if err != nil {
+		return "", err
+	}
+	c, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return "", err
+	}
+	dd, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return "", err
+	}
+	//the diff has to show what is applied, the namespace picked here is remembered for the apply
+	objects, namespace, err := prepareObjects(ctx, completion, annotations)
+	if err != nil {
+		return "", err
+	}
+
+	patterns := diffIgnorePatterns()
+	var diff strings.Builder
+	for _, obj := range objects {
+		mapping, err := restMapping(c, obj.GroupVersionKind())
+		if err != nil {
+			return "", err
+		}
+		var dri dynamic.ResourceInterface = dd.Resource(mapping.Resource)
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			if obj.GetNamespace() == "" {
+				obj.SetNamespace(namespace)
+			}
+			dri = dd.Resource(mapping.Resource).Namespace(obj.GetNamespace())
+		}
+
+		var liveObject map[string]interface{}
+		live, err := dri.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			//nothing to compare with, the whole object is added
+		case err != nil:
+			return "", err
+		default:
+			liveObject = normalizeForDiff(live.Object, patterns)
+		}
+		//the dry-run fails e.g. for objects in a namespace the manifest creates, then the generated object is shown
+		applied, err := dryRunObject(ctx, dri, obj)
+		if err != nil {
+			log.Debugf("unable to dry-run %s, diffing the generated object: %v", obj.GetKind()+"/"+obj.GetName(), err)
+			applied = obj
+		}
+		appliedObject := normalizeForDiff(applied.Object, patterns)
+		//the diff is printed and sent to the model, neither may show the values of secrets
+		redactSecretDiff(liveObject, appliedObject)
+
+		var from []byte
+		if liveObject != nil {
+			if from, err = yaml.Marshal(liveObject); err != nil {
+				return "", err
+			}
+		}
+		to, err := yaml.Marshal(appliedObject)
+		if err != nil {
+			return "", err
+		}
+		ref := objectRef(mapping, obj)
+		diff.WriteString(unifiedDiff(string(from), string(to), "live/"+ref, "generated/"+ref))
+	}
+	return diff.String(), nil
+}
+
+// redactSecretDiff redacts the values of the live and the generated Secret with redactSecret. Like kubectl diff
+// does, a value which changes is marked with (before) and (after), so the diff still shows which keys change.
+// live is nil if the object doesn't exist yet.
+func redactSecretDiff(live, generated map[string]interface{}) {
+	if kind, _, _ := unstructured.NestedString(generated, "kind"); kind != "Secret" {
+		return
+	}
+	changed := map[string]bool{}
+	if live != nil {
+		for _, field := range []string{"data", "stringData"} {
+			before, _, _ := unstructured.NestedMap(live, field)
+			after, _, _ := unstructured.NestedMap(generated, field)
+			for key, value := range after {
+				if old, ok := before[key]; ok && old != value {
+					changed[field+"."+key] = true
+				}
+			}
+		}
+		redactSecret(&unstructured.Unstructured{Object: live})
+	}
+	redactSecret(&unstructured.Unstructured{Object: generated})
+
+	for _, obj := range []struct {
+		object map[string]interface{}
+		suffix string
+	}{{live, " (before)"}, {generated, " (after)"}} {
+		for _, field := range []string{"data", "stringData"} {
+			values, _, _ := unstructured.NestedMap(obj.object, field)
+			for key := range values {
+				if changed[field+"."+key] {
+					values[key] = redactedValue + obj.suffix
+				}
+			}
+			if len(values) > 0 {
+				_ = unstructured.SetNestedMap(obj.object, values, field)
+			}
+		}
+	}
+}
+
+// defaultDiffIgnore are the fields managed by the server, they differ for every live object and only add noise
+var defaultDiffIgnore = []string{
+	"status",
+	"metadata.managedFields",
+	"metadata.resourceVersion",
+	"metadata.uid",
+	"metadata.creationTimestamp",
+	"metadata.generation",
+	"metadata.selfLink",
+}
+
+// diffIgnorePatterns returns the fields left out of diffs, the defaults and the patterns of the diff-ignore flag.
+// Like in a .gitignore a pattern starting with ! includes a field again, e.g. !status shows the status.
+func diffIgnorePatterns() []string {
+	patterns := append(append([]string{}, defaultDiffIgnore...), *diffIgnore...)
+	var included []string
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "!") {
+			included = append(included, strings.TrimPrefix(p, "!"))
+		}
+	}
+	var ignored []string
+	for _, p := range patterns {
+		if !strings.HasPrefix(p, "!") && !slices.Contains(included, p) {
+			ignored = append(ignored, p)
+		}
+	}
+	return ignored
+}
+
+// normalizeForDiff returns a copy of the object without the fields matching the patterns. Patterns are field
+// paths like metadata.uid, a * in a segment matches any characters including dots, e.g.
+// metadata.annotations.deployment* matches deployment.kubernetes.io/revision. Lists are matched element by
+// element without a segment of their own, e.g. spec.template.spec.containers.imagePullPolicy.
+func normalizeForDiff(obj map[string]interface{}, patterns []string) map[string]interface{} {
+	normalized := runtime.DeepCopyJSON(obj)
+	for _, p := range patterns {
+		removeField(normalized, strings.Split(p, "."))
+	}
+	//the server always drops empty metadata maps, so they shouldn't show up as a difference either
+	if metadata, ok := normalized["metadata"].(map[string]interface{}); ok {
+		for _, k := range []string{"annotations", "labels"} {
+			if m, ok := metadata[k].(map[string]interface{}); ok && len(m) == 0 {
+				delete(metadata, k)
+			}
+		}
+	}
+	return normalized
+}
+
+// removeField removes the fields matching the path segments from the value.
+func removeField(value interface{}, segments []string) {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			removeField(item, segments)
+		}
+	case map[string]interface{}:
+		for k, child := range v {
+			if !matchWildcard(segments[0], k) {
+				continue
+			}
+			if len(segments) == 1 {
+				delete(v, k)
+				continue
+			}
+			removeField(child, segments[1:])
+		}
+	}
+}
+
+// matchWildcard reports whether the text matches the pattern, * matches any characters including dots and slashes.
+func matchWildcard(pattern, text string) bool {
+	if !strings.Contains(pattern, "*") {
+		return pattern == text
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(text, parts[0]) {
+		return false
+	}
+	text = text[len(parts[0]):]
+	for i, part := range parts[1:] {
+		//the last part has to be at the end, the parts in between anywhere in order
+		if i == len(parts)-2 {
+			return strings.HasSuffix(text, part)
+		}
+		idx := strings.Index(text, part)
+		if idx < 0 {
+			return false
+		}
+		text = text[idx+len(part):]
+	}
+	return true
+}
+
+// unifiedDiff returns the difference of the lines of from and to in the unified format of diff -u,
+// or nothing if they are the same.
+func unifiedDiff(from, to, fromName, toName string) string {
+	a, b := splitLines(from), splitLines(to)
+
+	//lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] > lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	//walk the table to get the edit script, each line prefixed with space, - or +
+	type line struct {
+		op   byte
+		text string
+		//line numbers in a and b, 1-based, of the line or of the position it would be at
+		aLine, bLine int
+	}
+	var lines []line
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, line{' ', a[i], i + 1, j + 1})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, line{'-', a[i], i + 1, j + 1})
+			i++
+		default:
+			lines = append(lines, line{'+', b[j], i + 1, j + 1})
+			j++
+		}
+	}
+
+	//group the changes into hunks with diffContext unchanged lines around them
+	var out strings.Builder
+	for start := 0; start < len(lines); {
+		if lines[start].op == ' ' {
+			start++
+			continue
+		}
+		first := start - diffContext
+		if first < 0 {
+			first = 0
+		}
+		end := start
+		for k := start; k < len(lines) && k <= end+2*diffContext; k++ {
+			if lines[k].op != ' ' {
+				end = k
+			}
+		}
+		last := end + diffContext
+		if last > len(lines)-1 {
+			last = len(lines) - 1
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		var aCount, bCount int
+		for _, l := range lines[first : last+1] {
+			if l.op != '+' {
+				aCount++
+			}
+			if l.op != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", hunkStart(lines[first].aLine, aCount), aCount, hunkStart(lines[first].bLine, bCount), bCount)
+		for _, l := range lines[first : last+1] {
+			fmt.Fprintf(&out, "%c%s\n", l.op, l.text)
+		}
+		start = last + 1
+	}
+	return out.String()
+}
+
+// hunkStart returns the start line of a hunk, diff uses the line before the hunk for empty ranges.
+func hunkStart(line, count int) int {
+	if count == 0 {
+		return line - 1
+	}
+	return line
+}
+
+// splitLines splits the text into lines without the trailing newline.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// explainDiff asks the model for a short plain-English summary of the diff, like
+// "adds a second replica and a memory limit".
+func explainDiff(ctx context.Context, client oaiClients, diff string) (string, error) {
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "Summarize in one or two short plain-English sentences what applying the following change to the Kubernetes cluster does, e.g. \"adds a second replica and a memory limit\". Lines starting with - are the live objects, lines starting with + the new manifest. Ignore fields set by the server like status, uid, resourceVersion and managedFields. Only reply with the summary.\n%s", diff)
+	summary, _, err := completeWithRetry(ctx, client, &prompt, float32(*temperature), *openAIDeploymentName, nil)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(summary), nil
+}
+
+// kubectlDiff returns the output of kubectl diff for the objects of the manifest, for users who trust kubectl's
+// diff more than ours. The manifest is piped to kubectl diff -f - with the names and namespace that are applied.
+func kubectlDiff(ctx context.Context, completion string, annotations map[string]string) (string, error) {
+	objects, namespace, err := prepareObjects(ctx, completion, annotations)
+	if err != nil {
+		return "", err
+	}
+
+	var manifest bytes.Buffer
+	for _, obj := range objects {
+		data, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return "", err
+		}
+		manifest.WriteString("---\n")
+		manifest.Write(data)
+	}
+
+	out, err := runKubectlCommandWithInput(&manifest, "diff", "-f", "-", "--kubeconfig", getKubeConfig(), "--namespace", namespace)
+	//kubectl diff exits with 1 if there are differences, only greater exit codes are errors
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return string(out), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("kubectl diff failed: %w", err)
+	}
+	return string(out), nil
+}
+
+// printDiff prints the diff of the manifest against the cluster, kubectl's with the kubectl-diff flag,
+// and with the explain-diff flag the model's summary of it.
+func printDiff(ctx context.Context, client oaiClients, completion string, annotations map[string]string) error {
+	var diff string
+	var err error
+	if *kubectlDiffFlag {
+		diff, err = kubectlDiff(ctx, completion, annotations)
+	} else {
+		diff, err = diffManifest(ctx, completion, annotations)
+	}
+	if err != nil {
+		return err
+	}
+	if diff == "" {
+		fmt.Println("No changes compared to the cluster")
+		return nil
+	}
+	fmt.Print(diff)
+	if !*explainDiffFlag {
+		return nil
+	}
+	summary, err := explainDiff(ctx, client, diff)
+	if err != nil {
+		return fmt.Errorf("unable to explain the diff: %w", err)
+	}
+	fmt.Printf("%s%s\n", emoji("📝"), summary)
+	return nil
+}
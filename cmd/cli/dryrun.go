@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// webhookDenialPattern matches the message the API server returns when a validating or mutating webhook
+// denies a request, e.g. admission webhook "validation.gatekeeper.sh" denied the request: [require-labels] ...
+var webhookDenialPattern = regexp.MustCompile(`admission webhook "([^"]+)" denied the request: `)
+
+// admissionDenial is a request the admission webhooks of the cluster denied
+type admissionDenial struct {
+	webhook    string
+	violations []string
+}
+
+// serverDryRun applies the manifest with a server-side dry-run, so the API server and the admission webhooks
+// of the cluster, e.g. OPA Gatekeeper or Kyverno, check every object without anything being persisted. The
+// result of every object is printed, a denial with the messages of the webhook. It returns an error if any
+// object was denied or rejected.
+func serverDryRun(ctx context.Context, completion string, annotations map[string]string) error {
+	config, err := getRestConfig(getKubeConfig())
+	if err != nil {
+		return err
+	}
+	c, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	dd, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	//the webhooks have to see what is applied, the namespace picked here is remembered for the apply
+	objects, namespace, err := prepareObjects(ctx, completion, annotations)
+	if err != nil {
+		return err
+	}
+	orderObjects(objects)
+
+	//nothing is persisted, so objects of the manifest are missing for the objects which depend on them
+	created := map[string]bool{}
+	for _, obj := range objects {
+		if obj.GetKind() == "Namespace" {
+			created[obj.GetName()] = true
+		}
+	}
+
+	fmt.Printf("%sDry-running the manifest on the server\n", emoji("🛡"))
+	var denied, failed int
+	for _, obj := range objects {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		mapping, err := restMapping(c, obj.GroupVersionKind())
+		if err != nil {
+			//e.g. a custom resource of a CRD in the same manifest
+			fmt.Printf("%s/%s not checked: %v\n", obj.GetKind(), obj.GetName(), err)
+			continue
+		}
+		ref := objectRef(mapping, obj)
+		var dri dynamic.ResourceInterface = dd.Resource(mapping.Resource)
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			if obj.GetNamespace() == "" {
+				obj.SetNamespace(namespace)
+			}
+			dri = dd.Resource(mapping.Resource).Namespace(obj.GetNamespace())
+		}
+
+		_, err = dryRunObject(ctx, dri, obj)
+		switch d, ok := admissionDenialOf(err); {
+		case err == nil:
+			fmt.Printf("%s passed\n", ref)
+		case ok:
+			denied++
+			fmt.Printf("%s%s denied by %s:\n", emoji("🚫"), ref, d.webhook)
+			for _, v := range d.violations {
+				fmt.Printf("  - %s\n", v)
+			}
+		case apierrors.IsNotFound(err) && created[obj.GetNamespace()]:
+			fmt.Printf("%s not checked, its namespace %s is created by the manifest\n", ref, obj.GetNamespace())
+		default:
+			failed++
+			fmt.Printf("%s%s rejected: %v\n", emoji("❌"), ref, err)
+		}
+	}
+
+	switch {
+	case denied > 0 && failed > 0:
+		return fmt.Errorf("%d objects were denied by admission webhooks and %d were rejected by the API server", denied, failed)
+	case denied > 0:
+		return fmt.Errorf("%d objects were denied by admission webhooks", denied)
+	case failed > 0:
+		return fmt.Errorf("%d objects were rejected by the API server", failed)
+	}
+	return nil
+}
+
+// dryRunObject applies a single object with a server-side dry-run, with the same field manager and the same
+// last-applied annotation as the real apply. It returns the object as the API server would store it, with the
+// defaults of the server.
+func dryRunObject(ctx context.Context, dri dynamic.ResourceInterface, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if *trackLastApplied {
+		if err := setLastAppliedAnnotation(obj); err != nil {
+			return nil, err
+		}
+	}
+	return dri.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{
+		FieldManager: "application/apply-patch",
+		DryRun:       []string{metav1.DryRunAll},
+	})
+}
+
+// admissionDenialOf returns the webhook and its messages if the error is an admission webhook denial.
+// Gatekeeper reports one violation per line, every line is a violation.
+func admissionDenialOf(err error) (admissionDenial, bool) {
+	var status apierrors.APIStatus
+	if err == nil || !errors.As(err, &status) {
+		return admissionDenial{}, false
+	}
+	message := status.Status().Message
+	match := webhookDenialPattern.FindStringSubmatchIndex(message)
+	if match == nil {
+		return admissionDenial{}, false
+	}
+
+	d := admissionDenial{webhook: message[match[2]:match[3]]}
+	for _, line := range strings.Split(message[match[1]:], "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			d.violations = append(d.violations, line)
+		}
+	}
+	if len(d.violations) == 0 {
+		d.violations = []string{"no reason given"}
+	}
+	return d, true
+}
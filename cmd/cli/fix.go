@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// maxFixEvents is how many of the most recent events of the pod are added to the prompt
+const maxFixEvents = 20
+
+// fixInstructions asks the model for a corrected manifest instead of a new one
+const fixInstructions = "The following Kubernetes workload is failing. Diagnose the problem from the diagnostic information and generate the corrected manifest of the resource that has to change. If the pod is owned by a controller like a Deployment, generate the corrected controller instead of the pod. "
+
+// fixDiagnostics is the diagnostic input read from stdin, it's kept for every run in watch mode
+var fixDiagnostics string
+
+// readDiagnosticInput reads the diagnostic input piped to stdin with the fix flag, e.g. the output of
+// kubectl describe. Nothing is read when stdin is a terminal.
+func readDiagnosticInput() (string, error) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", nil
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("unable to read diagnostic input from stdin: %w", err)
+	}
+	//the confirmation can't be read from stdin anymore, see answerReader
+	stdinConsumed = true
+	return strings.TrimSpace(string(data)), nil
+}
+
+// fixPrompt returns the prompt for the fix flag with the diagnostic input and, with the from-pod flag,
+// the spec, status and recent events of the pod gathered from the cluster.
+func fixPrompt(ctx context.Context, diagnostics string) (string, error) {
+	var prompt strings.Builder
+	prompt.WriteString(fixInstructions)
+	if diagnostics != "" {
+		fmt.Fprintf(&prompt, "\nDiagnostic output:\n%s\n", diagnostics)
+	}
+	if *fromPod != "" {
+		podInfo, err := describePod(ctx, *fromPod)
+		if err != nil {
+			return "", err
+		}
+		prompt.WriteString(podInfo)
+	}
+	return prompt.String(), nil
+}
+
+// describePod returns the pod as YAML, without managed fields, followed by its most recent events.
+func describePod(ctx context.Context, name string) (string, error) {
+	kubeConfig := getKubeConfig()
+	config, err := getRestConfig(kubeConfig)
+	if err != nil {
+		return "", err
+	}
+	c, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return "", err
+	}
+	namespace, err := getNamespace(kubeConfig)
+	if err != nil {
+		return "", err
+	}
+
+	pod, err := c.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("unable to get pod %s: %w", name, err)
+	}
+	//managed fields are noise for the model
+	pod.ManagedFields = nil
+	pod.APIVersion, pod.Kind = "v1", "Pod"
+	podYAML, err := yaml.Marshal(pod)
+	if err != nil {
+		return "", err
+	}
+
+	events, err := c.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.AndSelectors(
+			fields.OneTermEqualSelector("involvedObject.kind", "Pod"),
+			fields.OneTermEqualSelector("involvedObject.name", name),
+		).String(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to list events of pod %s: %w", name, err)
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "\nPod %s in namespace %s:\n%s\nRecent events:\n", name, namespace, podYAML)
+	for _, e := range recentEvents(events.Items, maxFixEvents) {
+		fmt.Fprintf(&out, "%s %s %s: %s (x%d)\n", eventTime(e), e.Type, e.Reason, e.Message, e.Count)
+	}
+	return out.String(), nil
+}
+
+// eventTime returns when the event last happened, newer events only set the event time.
+func eventTime(e corev1.Event) string {
+	return lastOccurrence(e).UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// lastOccurrence returns when the event last happened, newer events only set the event time.
+func lastOccurrence(e corev1.Event) time.Time {
+	if !e.LastTimestamp.IsZero() {
+		return e.LastTimestamp.Time
+	}
+	return e.EventTime.Time
+}
+
+// recentEvents returns the n most recent events, oldest first. The API server doesn't list events in the
+// order they happened, so they are sorted first.
+func recentEvents(events []corev1.Event, n int) []corev1.Event {
+	events = append([]corev1.Event{}, events...)
+	sort.SliceStable(events, func(i, j int) bool {
+		return lastOccurrence(events[i]).Before(lastOccurrence(events[j]))
+	})
+	if len(events) > n {
+		events = events[len(events)-n:]
+	}
+	return events
+}
@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// values of the yaml-key-order flag
+const (
+	keyOrderKubernetes   = "kubernetes"
+	keyOrderAlphabetical = "alphabetical"
+)
+
+// defaultYAMLIndent is used when only the key order is set
+const defaultYAMLIndent = 2
+
+// kubernetesKeyOrder is the order of the well-known keys with the kubernetes key order,
+// other keys follow in the order the model generated them
+var kubernetesKeyOrder = map[string][]string{
+	"":         {"apiVersion", "kind", "metadata"},
+	"metadata": {"name", "namespace", "labels", "annotations"},
+}
+
+// formatEnabled reports whether the manifest is re-serialized instead of shown as the model formatted it.
+func formatEnabled() bool {
+	return *yamlIndent > 0 || *yamlKeyOrder != ""
+}
+
+// formatManifest re-serializes every document of the manifest with the indentation of the yaml-indent
+// flag and the key order of the yaml-key-order flag, so the output follows the same style no matter
+// which model generated it. Comments are kept.
+func formatManifest(completion string) (string, error) {
+	indent := *yamlIndent
+	if indent == 0 {
+		indent = defaultYAMLIndent
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(indent)
+	decoder := yaml.NewDecoder(bytes.NewReader([]byte(completion)))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return "", fmt.Errorf("unable to format manifest: %w", err)
+		}
+		//skip empty documents, e.g. a leading ---
+		if len(doc.Content) == 0 || doc.Content[0].Kind == yaml.ScalarNode && doc.Content[0].Tag == "!!null" {
+			continue
+		}
+		orderKeys(doc.Content[0], "")
+		if err := encoder.Encode(&doc); err != nil {
+			return "", err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// orderKeys orders the keys of the mapping node and its children according to the yaml-key-order flag
+// and switches them to block style, parent is the key of the node in its parent mapping.
+func orderKeys(node *yaml.Node, parent string) {
+	//flow style like {a: b} is the model's formatting too, everything is written in block style
+	node.Style &^= yaml.FlowStyle
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			orderKeys(child, "")
+		}
+		return
+	case yaml.MappingNode:
+	default:
+		return
+	}
+
+	//a mapping node has the keys and values alternating in its content
+	type pair struct{ key, value *yaml.Node }
+	var pairs []pair
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		pairs = append(pairs, pair{node.Content[i], node.Content[i+1]})
+		orderKeys(node.Content[i+1], node.Content[i].Value)
+	}
+
+	switch *yamlKeyOrder {
+	case keyOrderAlphabetical:
+		sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].key.Value < pairs[j].key.Value })
+	case keyOrderKubernetes:
+		priority := map[string]int{}
+		for i, key := range kubernetesKeyOrder[parent] {
+			priority[key] = i + 1
+		}
+		rank := func(key string) int {
+			if p, ok := priority[key]; ok {
+				return p
+			}
+			return len(priority) + 1
+		}
+		sort.SliceStable(pairs, func(i, j int) bool { return rank(pairs[i].key.Value) < rank(pairs[j].key.Value) })
+	}
+
+	node.Content = node.Content[:0]
+	for _, p := range pairs {
+		node.Content = append(node.Content, p.key, p.value)
+	}
+}
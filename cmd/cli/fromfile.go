@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// fileContentPlaceholder is the value the model puts where the content of a local file goes,
+// the content never passes through the model so it can't be corrupted
+const fileContentPlaceholder = "FILE_CONTENT:"
+
+// fileSource is a local file of the from-file flag, key is the key in the ConfigMap or Secret
+type fileSource struct {
+	key  string
+	path string
+}
+
+// parseFileSources parses the from-file flag. Like kubectl create configmap --from-file an entry
+// is either a path, with the file name as key, or key=path.
+func parseFileSources(specs []string) ([]fileSource, error) {
+	var sources []fileSource
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		key, path, ok := strings.Cut(spec, "=")
+		if !ok {
+			key, path = filepath.Base(spec), spec
+		}
+		if key == "" || path == "" {
+			return nil, fmt.Errorf("invalid --from-file %q, expected path or key=path", spec)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate --from-file key %q", key)
+		}
+		seen[key] = true
+		sources = append(sources, fileSource{key: key, path: path})
+	}
+	return sources, nil
+}
+
+// fromFilePrompt tells the model which files are available and how to reference them.
+func fromFilePrompt(sources []fileSource) string {
+	var keys []string
+	for _, s := range sources {
+		keys = append(keys, s.key)
+	}
+	return fmt.Sprintf("The user provides the local files %s. Where the content of one of these files goes into the data of a ConfigMap or the stringData of a Secret, use the file name as key and exactly %s followed by the file name as value, e.g. %s%s, never make up the content. ",
+		strings.Join(keys, ", "), fileContentPlaceholder, fileContentPlaceholder, keys[0])
+}
+
+// embedFiles replaces the file placeholders in the ConfigMaps and Secrets of the manifest with the
+// content of the files. Content of ConfigMaps which isn't valid UTF-8 goes to binaryData, Secrets
+// always get the base64 encoded content in data. The manifest is returned unchanged if it has no placeholders.
+func embedFiles(completion string, sources []fileSource) (string, error) {
+	objects, err := decodeManifest(completion)
+	if err != nil {
+		return "", err
+	}
+
+	files := map[string][]byte{}
+	for _, s := range sources {
+		content, err := os.ReadFile(s.path)
+		if err != nil {
+			return "", fmt.Errorf("unable to read --from-file: %w", err)
+		}
+		files[s.key] = content
+	}
+
+	embedded := 0
+	for _, obj := range objects {
+		var fields []string
+		switch obj.GetKind() {
+		case "ConfigMap":
+			fields = []string{"data"}
+		case "Secret":
+			fields = []string{"stringData", "data"}
+		default:
+			continue
+		}
+		for _, field := range fields {
+			values, _, _ := unstructured.NestedStringMap(obj.Object, field)
+			for key, value := range values {
+				content, ok := files[strings.TrimPrefix(value, fileContentPlaceholder)]
+				if !strings.HasPrefix(value, fileContentPlaceholder) || !ok {
+					continue
+				}
+				unstructured.RemoveNestedField(obj.Object, field, key)
+				if remaining, _, _ := unstructured.NestedMap(obj.Object, field); len(remaining) == 0 {
+					unstructured.RemoveNestedField(obj.Object, field)
+				}
+				if obj.GetKind() == "ConfigMap" && utf8.Valid(content) {
+					err = unstructured.SetNestedField(obj.Object, string(content), "data", key)
+				} else if obj.GetKind() == "ConfigMap" {
+					err = unstructured.SetNestedField(obj.Object, base64.StdEncoding.EncodeToString(content), "binaryData", key)
+				} else {
+					err = unstructured.SetNestedField(obj.Object, base64.StdEncoding.EncodeToString(content), "data", key)
+				}
+				if err != nil {
+					return "", err
+				}
+				embedded++
+			}
+		}
+	}
+	if embedded == 0 {
+		log.Warnf("the generated manifest doesn't reference any of the --from-file files")
+		return completion, nil
+	}
+	log.Debugf("embedded %d files into the manifest", embedded)
+
+	return encodeManifest(objects)
+}
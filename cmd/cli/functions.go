@@ -49,6 +49,8 @@ func (s *schemaNames) Run() (content string, err error) {
 	if err != nil {
 		return "", err
 	}
+	//the schemas of some of the names are usually asked for next, see schemafetch.go
+	prefetchGroupSchemas(names)
 
 	// Join names with newline separator in single string and send
 	return strings.Join(names, "\n"), nil
@@ -87,7 +89,8 @@ func (s *schema) Run() (content string, err error) {
 	}
 
 	// Marshal the schema into JSON because we will return it as string from this func.
-	schemaBytes, err := json.Marshal(schema)
+	//large schemas are trimmed to max-schema-tokens so they don't blow the context, see schemasize.go
+	schemaBytes, err := capSchema(schema, *maxSchemaTokens)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,80 @@
+package cli
+
+import (
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// haTopologyKey spreads the pods of highly available workloads across nodes
+const haTopologyKey = "kubernetes.io/hostname"
+
+// haPrompt asks the model for workloads which survive the loss of a node
+const haPrompt = "The workloads must be highly available: give every Deployment and StatefulSet at least 2 replicas and spread their pods across nodes with topologySpreadConstraints on " + haTopologyKey + " or a podAntiAffinity selecting the pods of the workload. "
+
+// haKinds are the replicated workloads the ha flag spreads, with the path to their pod template
+var haKinds = map[string][]string{
+	"Deployment":  {"spec", "template"},
+	"StatefulSet": {"spec", "template"},
+}
+
+// addHighAvailability makes sure every Deployment and StatefulSet of the manifest spreads its pods across
+// nodes. Workloads with neither topologySpreadConstraints nor a podAntiAffinity get a topology spread
+// constraint on the selector labels, which prefers different nodes but still schedules when there are
+// too few. Every fix of the model's output is logged as a warning, as are workloads with a single replica.
+// The manifest is returned unchanged if nothing had to be fixed.
+func addHighAvailability(completion string) (string, error) {
+	objects, err := decodeManifest(completion)
+	if err != nil {
+		return "", err
+	}
+
+	fixed := false
+	for _, obj := range objects {
+		templatePath, ok := haKinds[obj.GetKind()]
+		if !ok {
+			continue
+		}
+		ref := obj.GetKind() + "/" + obj.GetName()
+		//without replicas the API server defaults to 1
+		if replicas, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas"); !found || replicas < 2 {
+			log.Warnf("%s has a single replica, it isn't highly available", ref)
+		}
+
+		podSpecPath := fieldPath(templatePath, "spec")
+		constraints, _, _ := unstructured.NestedSlice(obj.Object, fieldPath(podSpecPath, "topologySpreadConstraints")...)
+		antiAffinity, _, _ := unstructured.NestedMap(obj.Object, fieldPath(podSpecPath, "affinity", "podAntiAffinity")...)
+		if len(constraints) > 0 || len(antiAffinity) > 0 {
+			continue
+		}
+
+		//the constraint has to select the pods of the workload, the selector does that by definition
+		labels, _, _ := unstructured.NestedStringMap(obj.Object, "spec", "selector", "matchLabels")
+		if len(labels) == 0 {
+			labels, _, _ = unstructured.NestedStringMap(obj.Object, fieldPath(templatePath, "metadata", "labels")...)
+		}
+		if len(labels) == 0 {
+			log.Warnf("%s has no topology spread constraints or pod anti-affinity and no labels to select its pods, unable to add them", ref)
+			continue
+		}
+		matchLabels := map[string]interface{}{}
+		for k, v := range labels {
+			matchLabels[k] = v
+		}
+		constraint := map[string]interface{}{
+			"maxSkew":           int64(1),
+			"topologyKey":       haTopologyKey,
+			"whenUnsatisfiable": "ScheduleAnyway",
+			"labelSelector":     map[string]interface{}{"matchLabels": matchLabels},
+		}
+		log.Warnf("%s has no topology spread constraints or pod anti-affinity, spreading its pods across nodes", ref)
+		if err := unstructured.SetNestedSlice(obj.Object, []interface{}{constraint}, fieldPath(podSpecPath, "topologySpreadConstraints")...); err != nil {
+			return "", err
+		}
+		fixed = true
+	}
+	if !fixed {
+		return completion, nil
+	}
+
+	return encodeManifest(objects)
+}
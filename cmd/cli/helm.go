@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+// formatHelmChart is the format flag value which generates a Helm chart instead of manifests
+const formatHelmChart = "helm-chart"
+
+// chartFileMarker starts every file of a generated Helm chart, followed by the path of the file in the chart
+const chartFileMarker = "# File: "
+
+// helmChartPrompt asks the model for the files of a chart instead of plain manifests
+const helmChartPrompt = "Instead of plain manifests generate a minimal Helm chart for the application: a Chart.yaml with apiVersion: v2, a name and a version, a values.yaml with the settings users are likely to change, and one file per object in templates/ using Go templates with .Values, .Release.Name and .Chart. Start every file with a line " + chartFileMarker + "followed by its path in the chart, e.g. " + chartFileMarker + "templates/deployment.yaml, and don't separate the files with ---. "
+
+// chartFilePattern matches the marker lines which separate the files of a chart
+var chartFilePattern = regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(chartFileMarker) + `(.+)$`)
+
+// helmTemplateFuncs stands in for the template functions Helm adds, so templates using them can be parsed.
+// The functions are never called, templates are only parsed.
+var helmTemplateFuncs = func() template.FuncMap {
+	funcs := template.FuncMap{}
+	for _, name := range []string{
+		"include", "tpl", "required", "lookup", "toYaml", "fromYaml", "toJson", "fromJson", "toToml",
+		"indent", "nindent", "quote", "squote", "default", "empty", "coalesce", "ternary", "trunc",
+		"trim", "trimAll", "trimSuffix", "trimPrefix", "replace", "lower", "upper", "title", "contains",
+		"hasPrefix", "hasSuffix", "regexMatch", "regexReplaceAll", "split", "splitList", "join", "cat",
+		"list", "dict", "hasKey", "get", "set", "unset", "merge", "mergeOverwrite", "keys", "values",
+		"first", "last", "until", "toString", "int", "int64", "float64", "add", "sub", "mul", "div",
+		"max", "min", "b64enc", "b64dec", "sha256sum", "randAlphaNum", "uuidv4", "now", "date",
+		"semverCompare", "kindIs", "typeOf", "deepCopy", "toStrings", "sortAlpha", "uniq", "compact",
+	} {
+		funcs[name] = func(...interface{}) interface{} { return nil }
+	}
+	return funcs
+}()
+
+// chartFile is a file of a generated Helm chart, path is relative to the chart directory
+type chartFile struct {
+	path    string
+	content string
+}
+
+// parseHelmChart splits the completion into the files of the chart and checks that the chart is usable:
+// Chart.yaml and values.yaml are valid YAML and the templates are valid Go templates.
+func parseHelmChart(completion string) ([]chartFile, error) {
+	markers := chartFilePattern.FindAllStringSubmatchIndex(completion, -1)
+	if len(markers) == 0 {
+		return nil, fmt.Errorf("the generated chart has no %q lines, unable to split it into files", strings.TrimSpace(chartFileMarker))
+	}
+
+	var files []chartFile
+	seen := map[string]bool{}
+	for i, m := range markers {
+		end := len(completion)
+		if i+1 < len(markers) {
+			end = markers[i+1][0]
+		}
+		path := filepath.Clean(strings.TrimSpace(completion[m[2]:m[3]]))
+		//the model decides the paths, they must stay inside the chart directory
+		if filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("the generated chart has a file outside of the chart: %s", path)
+		}
+		if seen[path] {
+			return nil, fmt.Errorf("the generated chart has %s more than once", path)
+		}
+		seen[path] = true
+		content := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(completion[m[1]:end]), "---"))
+		files = append(files, chartFile{path: path, content: content + "\n"})
+	}
+
+	for _, required := range []string{"Chart.yaml", "values.yaml"} {
+		if !seen[required] {
+			return nil, fmt.Errorf("the generated chart has no %s", required)
+		}
+	}
+	for _, f := range files {
+		switch {
+		case f.path == "Chart.yaml" || f.path == "values.yaml":
+			var v map[string]interface{}
+			if err := yaml.Unmarshal([]byte(f.content), &v); err != nil {
+				return nil, fmt.Errorf("the generated %s is not valid YAML: %w", f.path, err)
+			}
+		case strings.HasPrefix(f.path, "templates"+string(filepath.Separator)):
+			if _, err := template.New(f.path).Funcs(helmTemplateFuncs).Parse(f.content); err != nil {
+				return nil, fmt.Errorf("the generated template %s is not a valid Go template: %w", f.path, err)
+			}
+		}
+	}
+	return files, nil
+}
+
+// writeHelmChart writes the files of the chart to dir, the directory is created if it doesn't exist.
+// The manifest-header goes on top of every YAML file.
+func writeHelmChart(dir, completion string) error {
+	files, err := parseHelmChart(completion)
+	if err != nil {
+		return err
+	}
+	header, err := manifestHeader()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		path := filepath.Join(dir, f.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		content := f.content
+		if strings.HasSuffix(f.path, ".yaml") {
+			content = header + content
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			return err
+		}
+		log.Debugf("wrote %s", path)
+	}
+	fmt.Printf("%sWrote Helm chart with %d files to %s\n", emoji("✨"), len(files), dir)
+	return nil
+}
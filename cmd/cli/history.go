@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/manifoldco/promptui"
+)
+
+// historyEntry is a single line of the prompt history file
+type historyEntry struct {
+	Prompt string    `json:"prompt"`
+	Time   time.Time `json:"time"`
+}
+
+// defaultHistoryFile returns the default path of the prompt history file in the user's config directory.
+func defaultHistoryFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "kubectl-assistant", "history.jsonl")
+}
+
+// recordPrompt appends the prompt to the history file.
+// Nothing is recorded if no history file is configured or the no-history flag is set.
+func recordPrompt(prompt string) error {
+	if *historyFile == "" || *noHistory || strings.TrimSpace(prompt) == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(*historyFile), 0o700); err != nil {
+		return err
+	}
+	//the history may contain sensitive prompts, so only the user can read it
+	f, err := os.OpenFile(*historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	//every entry is a JSON line, so prompts containing newlines (e.g. from a prompt file) stay intact
+	return json.NewEncoder(f).Encode(historyEntry{Prompt: prompt, Time: time.Now()})
+}
+
+// readHistory returns the prompts of the history file, most recent first and without duplicates.
+func readHistory() ([]string, error) {
+	f, err := os.Open(*historyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	//prompts can be long, allow lines up to 1MB
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry historyEntry
+		//skip lines we can't parse instead of failing on a single broken entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var prompts []string
+	for i := len(entries) - 1; i >= 0; i-- {
+		if seen[entries[i].Prompt] {
+			continue
+		}
+		seen[entries[i].Prompt] = true
+		prompts = append(prompts, entries[i].Prompt)
+	}
+	return prompts, nil
+}
+
+// selectFromHistory lets the user pick a prompt from the history with the arrow keys,
+// typing / starts searching the prompts.
+func selectFromHistory() (string, error) {
+	prompts, err := readHistory()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if len(prompts) == 0 {
+		return "", fmt.Errorf("no prompts in history file %s", *historyFile)
+	}
+
+	prompt := promptui.Select{
+		Label: "Select a prompt from history",
+		Items: prompts,
+		Size:  10,
+		Searcher: func(input string, index int) bool {
+			return strings.Contains(strings.ToLower(prompts[index]), strings.ToLower(input))
+		},
+	}
+	_, result, err := prompt.Run()
+	if err != nil {
+		return "", err
+	}
+	return result, nil
+}
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// maxImageBytes is the largest image the OpenAI API accepts
+const maxImageBytes = 20 << 20
+
+// imageTypes are the content types of images vision models accept
+var imageTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// imagePrompt tells the model what the images of the image flag are for, the output stays plain YAML
+const imagePrompt = "The user provides images, e.g. architecture diagrams or sketches. Generate the Kubernetes objects for the components and connections shown in them, together with what else the user asks for. Only generate YAML, don't describe the images. "
+
+// readImage reads an image of the image flag and returns it as data URL, so it can be sent inline.
+func readImage(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read --image: %w", err)
+	}
+	if len(content) > maxImageBytes {
+		return "", fmt.Errorf("--image %s is larger than %d MB", path, maxImageBytes>>20)
+	}
+	contentType := http.DetectContentType(content)
+	supported := false
+	for _, t := range imageTypes {
+		supported = supported || t == contentType
+	}
+	if !supported {
+		return "", fmt.Errorf("--image %s is %s, expected one of %s", path, contentType, strings.Join(imageTypes, ", "))
+	}
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(content), nil
+}
+
+// imageParts returns the images of the image flag as parts of a chat message, the prompt goes in front of them.
+func imageParts() ([]openai.ChatMessagePart, error) {
+	var parts []openai.ChatMessagePart
+	for _, path := range *images {
+		url, err := readImage(path)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, openai.ChatMessagePart{
+			Type:     openai.ChatMessagePartTypeImageURL,
+			ImageURL: &openai.ChatMessageImageURL{URL: url, Detail: openai.ImageURLDetailAuto},
+		})
+	}
+	return parts, nil
+}
+
+// imagesDigest identifies the content of the images of the image flag, e.g. for the response cache.
+// It is empty without images.
+func imagesDigest() string {
+	if len(*images) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	for _, path := range *images {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			//unreadable images fail the request anyway
+			return ""
+		}
+		sum := sha256.Sum256(content)
+		h.Write(sum[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
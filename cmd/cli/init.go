@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/manifoldco/promptui"
+	"github.com/spf13/cobra"
+)
+
+const (
+	confirmYes = "Yes, ask before applying"
+	confirmNo  = "No, apply right away"
+)
+
+// initCmd returns the init subcommand, which asks for the settings needed to get started,
+// writes them to the config file and checks that OpenAI and the cluster can be reached.
+func initCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "init",
+		Short: "Set up the config file and verify the setup",
+		Long:  "Set up the config file with the OpenAI key, endpoint, default model and confirmation preference, then check that OpenAI and the Kubernetes cluster can be reached.",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer cancel()
+			return runInit(ctx)
+		},
+	}
+}
+
+// runInit asks for the settings, the current values are the defaults so running init again only changes
+// what the user edits. The flags are updated too, so the checks use the new settings.
+func runInit(ctx context.Context) error {
+	keyPrompt := promptui.Prompt{
+		Label: "OpenAI API key",
+		Mask:  '*',
+		Validate: func(input string) error {
+			if input == "" && *openAIAPIKey == "" {
+				return errors.New("the API key is required")
+			}
+			return nil
+		},
+	}
+	if *openAIAPIKey != "" {
+		keyPrompt.Label = "OpenAI API key (leave empty to keep the current key)"
+	}
+	key, err := keyPrompt.Run()
+	if err != nil {
+		return err
+	}
+
+	endpoint, err := (&promptui.Prompt{Label: "OpenAI endpoint", Default: *openAIEndpoint, AllowEdit: true}).Run()
+	if err != nil {
+		return err
+	}
+	model, err := (&promptui.Prompt{Label: "Default model", Default: *openAIDeploymentName, AllowEdit: true}).Run()
+	if err != nil {
+		return err
+	}
+
+	confirmPrompt := promptui.Select{
+		Label: "Require confirmation before applying",
+		Items: []string{confirmYes, confirmNo},
+	}
+	if !*requireConfirmation {
+		confirmPrompt.CursorPos = 1
+	}
+	_, confirm, err := confirmPrompt.Run()
+	if err != nil {
+		return err
+	}
+
+	values := map[string]string{
+		"openai-endpoint":        endpoint,
+		"openai-deployment-name": model,
+		"require-confirmation":   strconv.FormatBool(confirm == confirmYes),
+	}
+	if key != "" {
+		values["openai-api-key"] = key
+		*openAIAPIKey = key
+	}
+	*openAIEndpoint = endpoint
+	*openAIDeploymentName = model
+	*requireConfirmation = confirm == confirmYes
+
+	path, err := writeConfig(values)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%sWrote the config to %s\n", emoji("✨"), path)
+
+	return checkSetup(ctx)
+}
+
+// checkSetup checks that the OpenAI endpoint accepts the key and that the cluster can be reached,
+// it reports every check and returns an error if any of them failed.
+func checkSetup(ctx context.Context) error {
+	checks := []struct {
+		name  string
+		check func(context.Context) error
+	}{
+		{"OpenAI endpoint " + *openAIEndpoint, checkOpenAI},
+		{"Kubernetes cluster", checkCluster},
+	}
+
+	failed := false
+	for _, c := range checks {
+		if err := c.check(ctx); err != nil {
+			failed = true
+			fmt.Printf("%s%s: failed: %v\n", emoji("❌"), c.name, err)
+			continue
+		}
+		fmt.Printf("%s%s: ok\n", emoji("✅"), c.name)
+	}
+	if failed {
+		return errors.New("some checks failed, run init again or fix the config file")
+	}
+	return nil
+}
+
+// checkOpenAI lists the models, which requires a valid key but doesn't cost anything.
+func checkOpenAI(ctx context.Context) error {
+	clients, err := newOAIClients()
+	if err != nil {
+		return err
+	}
+	_, err = clients.openAIClient.ListModels(ctx)
+	return err
+}
+
+// checkCluster gets the version of the API server with the kubeconfig in use.
+func checkCluster(_ context.Context) error {
+	c, err := getClientset()
+	if err != nil {
+		return err
+	}
+	_, err = c.Discovery().ServerVersion()
+	return err
+}
@@ -1,36 +1,57 @@
 package cli
 
 import (
-	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/manifoldco/promptui"
+	"github.com/sethvargo/go-retry"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
-	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/apimachinery/pkg/types"
+	runtimeschema "k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/client-go/util/homedir"
+	"sigs.k8s.io/yaml"
 )
 
-const defaultNamespace = "default"
+const (
+	defaultNamespace = "default"
+	// inClusterNamespaceFile is where the namespace of the pod is mounted with the service account token
+	inClusterNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// errAborted is returned when the user aborted the rest of the apply with the confirm-each flag, the objects
+// before were applied, so the apply isn't reported as a success
+var errAborted = errors.New("aborted, the remaining objects were not applied")
 
 //completion string received here is the yaml file returned by chatgptcompletion api
 //we are calling this functin from root.go after asking the user whether he wants to apply
 // applyManifest applies the provided manifest to the Kubernetes cluster.
-func applyManifest(completion string) error {
+//ctx is the cancelable context created in run, so Ctrl-C also cancels in-flight apply requests
+//annotations are added to every object, it is nil unless the annotate flag is set
+func applyManifest(ctx context.Context, completion string, annotations map[string]string) error {
 	// Retrieve the Kubernetes configuration file path, just returns the file path
 	kubeConfig := getKubeConfig()
 
-	//pass the file path and get the config values
+	//pass the file path and get the config values, or the in-cluster config when running in a pod
 	// Build the Kubernetes client configuration from the provided kubeConfig file
-	config, err := clientcmd.BuildConfigFromFlags("", kubeConfig)
+	config, err := getRestConfig(kubeConfig)
 	if err != nil {
 		return err
 	}
@@ -50,92 +71,108 @@ func applyManifest(completion string) error {
 		return err
 	}
 
-	var namespace string
-	//we defined a variable kubernetesConfigFlags in root.go file to determine config flags for kubernetes
-	//if their namespace is not provided, then we get defaultNameSpace
-	if *kubernetesConfigFlags.Namespace == "" {
-		// If the namespace flag is not provided, retrieve the default namespace from the kubeConfig file
-		//call the getConfig function defined below
-		clientConfig, err := getConfig(kubeConfig)
-		if err != nil {
+	//we have received completion string as args in this function, before we can apply it
+	//as manifest, we need to decode it into objects with the changes of our flags, see prepareObjects
+	objects, namespace, err := prepareObjects(ctx, completion, annotations)
+	if err != nil {
+		return err
+	}
+	//which cluster we are talking to, so misrouted applies are easy to spot
+	logRestConfig(kubeConfig, config, namespace)
+
+	//with the explain-rbac flag we check all permissions before applying anything,
+	//so we don't fail with a forbidden error after half of the objects are applied
+	if *explainRBAC {
+		if err := checkApplyPermissions(ctx, c, objects, namespace); err != nil {
 			return err
 		}
-		//if even after getting kuubeConfig, in clientConfig, there's no namespace defined,
-		//use defaultNamespace
-		if clientConfig.Contexts[clientConfig.CurrentContext].Namespace == "" {
-			//defaultNameSpace constant is defined above in this file
-			namespace = defaultNamespace
-		} else {
-			namespace = clientConfig.Contexts[clientConfig.CurrentContext].Namespace
-		}
-	} else {
-		//else if configFlag's namespace has a value set, use that
-		// Use the provided namespace flag
-		namespace = *kubernetesConfigFlags.Namespace
 	}
 
-	//we have received completion string as args in this function, before we can apply it
-	//as manifest, we need to convert it
-	// Convert the completion string to a byte array
-	manifest := []byte(completion)
-
-	// Create a YAML or JSON decoder to decode the manifest
-	//note we are using YAMLorJSONDecoder, meaning we are prepared for both data types
-	decoder := yamlutil.NewYAMLOrJSONDecoder(bytes.NewReader(manifest), 100)
-
-	// Decode and apply each object in the manifest
-	for {
-		//runtime.RawExtension is a type provided by the Kubernetes client libraries. 
-		//It is used to represent arbitrary JSON or yaml data without unmarshaling it into a specific struct. 
-		//This can be useful in situations where you want to work with Kubernetes resources that have dynamic or unknown structures.
-		var rawObj runtime.RawExtension
-		//decoder already has the manifest file, we want to structure it like rawObj
-		//and decode it into the rawObj variable, since we don't know the structure of the JSON data
-		//at compile time, so need RawExtension, we will further process rawObj now
-		
-		if err = decoder.Decode(&rawObj); err != nil {
-			break
+	//the model doesn't always put referenced objects first, e.g. a ConfigMap after the Deployment
+	//mounting it, so we apply them in dependency order, see manifest.go
+	orderObjects(objects)
+
+	//when deleting we go through the objects in reverse order, so e.g. a namespace
+	//is deleted after the objects in it
+	if *deleteMode {
+		for i, j := 0, len(objects)-1; i < j; i, j = i+1, j-1 {
+			objects[i], objects[j] = objects[j], objects[i]
 		}
+	}
 
-		// Decode the raw object into a typed object using the YAML decoding serializer
-		//here obj is the decoded object for data that was stored in rawObj 
-		//we basically created a new yaml decodingSerializer to process JSON data into something golang understands
-		obj, gvk, err := yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme).Decode(rawObj.Raw, nil, nil)
-		//gvk is groupVersionKind data of the decoded object, provides info about the API group, version and kind of the resource
-		
-		if err != nil {
+	//every applied object is recorded, so an interrupted apply can be continued with the resume flag.
+	//The state of a different manifest is replaced, see resume.go
+	state, err := loadApplyState()
+	if err != nil {
+		log.Debugf("unable to load apply state: %v", err)
+	}
+	if state == nil || !*resume || state.ManifestSHA256 != manifestSHA256(completion) || state.Delete != *deleteMode {
+		state = &applyState{ManifestSHA256: manifestSHA256(completion), Manifest: completion, Annotations: annotations, Delete: *deleteMode}
+	}
+
+	//the previous version of every changed object, see undo.go
+	undo := newUndoState()
+
+	//like helm install --create-namespace, missing namespaces are created before anything is applied
+	if *createNamespace && !*deleteMode {
+		if err := createMissingNamespaces(ctx, c, objects, namespace, undo); err != nil {
 			return err
 		}
+	}
 
-		// Convert the strongly typed object that golang understands to an unstructured map
-		//so that we can process it further
-		unstructuredMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
-		if err != nil {
+	verb, done := "applying", "applied"
+	if *deleteMode {
+		verb, done = "deleting", "deleted"
+	}
+	progressf("%s %d objects", verb, len(objects))
+	//the custom resources of CRDs in the manifest get a few retries while discovery catches up, see crd.go
+	newKinds := manifestCRDKinds(objects)
+
+	// Apply each object in the manifest
+	for i, unstructuredObj := range objects {
+		// stop early if the user pressed Ctrl-C between documents
+		if err := ctx.Err(); err != nil {
 			return err
 		}
 
-		//we now have an unstructured map and need an unstructured object from it
-		// Create an unstructured object from the unstructured map
-		unstructuredObj := &unstructured.Unstructured{Object: unstructuredMap}
-
-		// Get the API group resources using the Kubernetes discovery API
-		//c is our kubernetes client
-		//get a mapping of API groups and the associated resources available in a Kubernetes cluster.
-		gr, err := restmapper.GetAPIGroupResources(c.Discovery())
+		//the mapper is built again for every object, so custom resources can be applied
+		//right after their CRD in the same manifest
+		mapping, err := restMappingForNewCRDs(ctx, c, unstructuredObj.GroupVersionKind(), newKinds)
 		if err != nil {
 			return err
 		}
 
-		// Create a REST mapper using the API group resources
-		//the gr variable contains info about the API group resources, we got this from above
-		//
-		mapper := restmapper.NewDiscoveryRESTMapper(gr)
+		//already applied before the last run was interrupted
+		key := objectRef(mapping, unstructuredObj)
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			ns := unstructuredObj.GetNamespace()
+			if ns == "" {
+				ns = namespace
+			}
+			key = ns + "/" + key
+		}
+		if *resume && state.isApplied(key) {
+			fmt.Printf("%s already applied, skipping\n", objectRef(mapping, unstructuredObj))
+			continue
+		}
 
-		// Get the REST mapping for the object's group version kind, since we want to call REST API to apply manifest
-		// A REST mapper is responsible for mapping group-version-resource (GVR) identifiers to their corresponding REST endpoints.
-		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
-		if err != nil {
-			return err
+		//with the confirm-each flag the user, or the approval webhook, approves every object of the manifest separately
+		if *confirmEach {
+			manifest, err := yaml.Marshal(unstructuredObj.Object)
+			if err != nil {
+				return err
+			}
+			action, err := newApprovalProvider().approveObject(ctx, objectRef(mapping, unstructuredObj), string(manifest))
+			if err != nil {
+				return err
+			}
+			if action == skip {
+				fmt.Printf("%s skipped\n", objectRef(mapping, unstructuredObj))
+				continue
+			}
+			if action == abortAll {
+				return errAborted
+			}
 		}
 
 		//we need a dynamic resource interfece and dri is a short form for it
@@ -170,15 +207,546 @@ if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
 		// Apply the object to the cluster using the dynamic client
 		//this line is the main business logic where the manifest is applied
 		//the purpose of the above if-else statement was to set the value for dri so we can use it to apply manifest
-		if _, err := dri.Apply(context.Background(), unstructuredObj.GetName(), unstructuredObj, metav1.ApplyOptions{FieldManager: "application/apply-patch"}); err != nil {
+		//in delete mode the objects of the manifest are deleted instead
+		//the live object before the change, it is recorded so the undo command can restore it
+		live, err := dri.Get(ctx, unstructuredObj.GetName(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			live = nil
+		} else if err != nil {
+			return err
+		}
+		undo.record(unstructuredObj, live)
+		if err := undo.save(); err != nil {
+			log.Debugf("unable to save undo state: %v", err)
+		}
+
+		var result string
+		if *deleteMode {
+			result, err = deleteObject(ctx, dri, unstructuredObj)
+		} else {
+			result, err = applyObject(ctx, dri, unstructuredObj, live)
+			//the resource of a CRD which was just established can briefly 404
+			if apierrors.IsNotFound(err) && newKinds[unstructuredObj.GroupVersionKind().GroupKind()] {
+				result, err = applyNewCRDObject(ctx, dri, unstructuredObj, err)
+			}
+		}
+		if err != nil {
 			return err
 		}
+		//print a summary line per object like kubectl apply does
+		if plainProgress() {
+			progressf("%s %d/%d %s %s", done, i+1, len(objects), objectRef(mapping, unstructuredObj), result)
+		} else {
+			fmt.Printf("%s %s\n", objectRef(mapping, unstructuredObj), result)
+		}
+
+		state.Applied = append(state.Applied, key)
+		if err := state.save(); err != nil {
+			log.Debugf("unable to save apply state: %v", err)
+		}
+	}
+	//nothing left to resume
+	if err := clearApplyState(); err != nil {
+		log.Debugf("unable to clear apply state: %v", err)
 	}
 //this function applies manifest and doesn't return any value, just an error,
 //so if everything went well, we'll return nil as the error
 	return nil
 }
 
+// prepareObjects decodes the manifest into the objects as they are applied: filtered by the only flag, renamed,
+// with the required labels, image pull secrets and annotations. It returns them with the namespace used for the
+// namespaced objects that don't specify one, see selectNamespace. The diff, the dry-runs and the other checks
+// use it too, so they see what the apply sends.
+func prepareObjects(ctx context.Context, completion string, annotations map[string]string) ([]*unstructured.Unstructured, string, error) {
+	objects, err := decodeManifest(completion)
+	if err != nil {
+		return nil, "", err
+	}
+
+	//with the only flag just some of the documents are applied, e.g. a ConfigMap first
+	objects, err = filterObjects(objects, *only)
+	if err != nil {
+		return nil, "", err
+	}
+
+	//our naming policy may require a prefix or suffix on every name, see rename.go
+	renameObjects(objects)
+	//the labels our policy requires on every object, see labels.go
+	if err := addRequiredLabels(objects); err != nil {
+		return nil, "", err
+	}
+	//the registry of the images may need a pull secret, see pullsecrets.go
+	if err := addImagePullSecrets(objects); err != nil {
+		return nil, "", err
+	}
+	//mark the objects as generated, so anyone inspecting the cluster can see where they came from
+	for _, obj := range objects {
+		addAnnotations(obj, annotations)
+	}
+
+	//the namespace used for namespaced objects that don't specify one themselves,
+	//when none is configured we let the user pick one interactively
+	c, err := getClientset()
+	if err != nil {
+		return nil, "", err
+	}
+	namespace, err := selectNamespace(ctx, c, getKubeConfig(), objects)
+	if err != nil {
+		return nil, "", err
+	}
+	return objects, namespace, nil
+}
+
+// results of applying a single object, printed in the summary
+const (
+	resultCreated    = "created"
+	resultConfigured = "configured"
+	resultUnchanged  = "unchanged"
+	resultDeleted    = "deleted"
+	resultNotFound   = "not found"
+	resultReplaced   = "replaced"
+)
+
+// applyObject applies a single object with server-side apply and returns what happened to it,
+// live is the object in the cluster before the apply or nil if it doesn't exist yet.
+// With the skip-unchanged flag the apply is skipped if it wouldn't change the live object,
+// so we don't bump the resourceVersion or trigger reconciliation.
+func applyObject(ctx context.Context, dri dynamic.ResourceInterface, obj, live *unstructured.Unstructured) (string, error) {
+	//legacy tooling and kubectl diff rely on the annotation client-side apply writes
+	if *trackLastApplied {
+		if err := setLastAppliedAnnotation(obj); err != nil {
+			return "", err
+		}
+	}
+
+	result := resultConfigured
+	switch {
+	case live == nil:
+		result = resultCreated
+	case *skipUnchanged && isUnchanged(ctx, dri, obj, live):
+		return resultUnchanged, nil
+	}
+
+	var err error
+	if *patchType != patchTypeApply {
+		result, err = patchObject(ctx, dri, obj, live, result)
+	} else {
+		_, err = dri.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{FieldManager: "application/apply-patch"})
+	}
+	//immutable fields like the selector of a Deployment can only be changed by recreating the object
+	if live != nil && *onImmutable == onImmutableReplace && isImmutableFieldError(err) {
+		return replaceObject(ctx, dri, obj, err)
+	}
+	if err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+// values of the on-immutable flag
+const (
+	onImmutableFail    = "fail"
+	onImmutableReplace = "replace"
+)
+
+// replaceTimeout is how long we wait for a replaced object to be gone before it is created again
+const replaceTimeout = 2 * time.Minute
+
+// isImmutableFieldError reports whether the API server rejected the object because it changes an immutable field.
+func isImmutableFieldError(err error) bool {
+	if !apierrors.IsInvalid(err) {
+		return false
+	}
+	var status apierrors.APIStatus
+	if errors.As(err, &status) && status.Status().Details != nil {
+		for _, cause := range status.Status().Details.Causes {
+			if strings.Contains(cause.Message, "immutable") {
+				return true
+			}
+		}
+	}
+	return strings.Contains(err.Error(), "immutable")
+}
+
+// replaceObject deletes the object and creates it again, like kubectl replace --force. Objects are only replaced
+// if the user confirmed the apply, see applyConfirmed, and confirms the replacement too, otherwise the immutable
+// field error applyErr is returned. Nothing is left of the old object, so its status and anything the server
+// set on it are lost.
+func replaceObject(ctx context.Context, dri dynamic.ResourceInterface, obj *unstructured.Unstructured, applyErr error) (string, error) {
+	ref := obj.GetKind() + "/" + obj.GetName()
+	log.Warnf("%s changes an immutable field: %v", ref, applyErr)
+	if !applyConfirmed {
+		return "", fmt.Errorf("%s is only recreated with --on-immutable=replace if the apply was confirmed: %w", ref, applyErr)
+	}
+	label := fmt.Sprintf("Would you like to delete and recreate %s", ref)
+	if promptTerminal() {
+		prompt := promptui.Prompt{Label: label, IsConfirm: true}
+		//promptui returns an error if the user doesn't confirm
+		if _, err := prompt.Run(); err != nil {
+			return "", applyErr
+		}
+	} else {
+		if ok, err := plainConfirm(label); err != nil || !ok {
+			return "", applyErr
+		}
+	}
+
+	//the dependents, e.g. the pods of a Deployment, go with the object and are created again
+	policy := metav1.DeletePropagationForeground
+	if err := dri.Delete(ctx, obj.GetName(), metav1.DeleteOptions{PropagationPolicy: &policy}); err != nil && !apierrors.IsNotFound(err) {
+		return "", fmt.Errorf("unable to delete %s to replace it: %w", ref, err)
+	}
+	//finalizers can keep the object around for a while, creating it too early fails with already exists
+	err := wait.PollUntilContextTimeout(ctx, time.Second, replaceTimeout, true, func(ctx context.Context) (bool, error) {
+		_, err := dri.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s was deleted but is still there, unable to recreate it: %w", ref, err)
+	}
+	//created with server-side apply, so the fields are owned like those of every other write
+	if _, err := dri.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{FieldManager: "application/apply-patch"}); err != nil {
+		return "", fmt.Errorf("%s was deleted but couldn't be created again: %w", ref, err)
+	}
+	return resultReplaced, nil
+}
+
+// patch types of the patch-type flag
+const (
+	patchTypeApply     = "apply"
+	patchTypeMerge     = "merge"
+	patchTypeStrategic = "strategic"
+)
+
+// patchObject applies a single object with the merge or strategic merge patch of the patch-type flag,
+// for resources where server-side apply causes issues. Patches only work on existing objects, so objects
+// which don't exist are created with server-side apply, the fields are owned like those of every other write.
+func patchObject(ctx context.Context, dri dynamic.ResourceInterface, obj, live *unstructured.Unstructured, result string) (string, error) {
+	if live == nil {
+		if _, err := dri.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{FieldManager: "application/apply-patch"}); err != nil {
+			return "", err
+		}
+		return result, nil
+	}
+
+	pt := types.MergePatchType
+	if *patchType == patchTypeStrategic {
+		pt = types.StrategicMergePatchType
+	}
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	if _, err := dri.Patch(ctx, obj.GetName(), pt, data, metav1.PatchOptions{FieldManager: "application/apply-patch"}); err != nil {
+		//custom resources don't support strategic merge patches
+		if apierrors.IsUnsupportedMediaType(err) && pt == types.StrategicMergePatchType {
+			return "", fmt.Errorf("%s doesn't support strategic merge patches, use --patch-type merge: %w", obj.GetKind(), err)
+		}
+		return "", err
+	}
+	return result, nil
+}
+
+// setLastAppliedAnnotation sets the kubectl.kubernetes.io/last-applied-configuration annotation to the
+// JSON of the object like kubectl apply does, the annotation itself is left out of the JSON.
+func setLastAppliedAnnotation(obj *unstructured.Unstructured) error {
+	copied := obj.DeepCopy()
+	annotations := copied.GetAnnotations()
+	delete(annotations, corev1.LastAppliedConfigAnnotation)
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	copied.SetAnnotations(annotations)
+
+	data, err := copied.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	//kubectl stores the JSON with a trailing newline
+	addAnnotations(obj, map[string]string{corev1.LastAppliedConfigAnnotation: string(data) + "\n"})
+	return nil
+}
+
+// deleteObject deletes a single object with the propagation policy of the delete-propagation flag.
+// Objects which don't exist are reported as not found instead of failing the whole manifest.
+func deleteObject(ctx context.Context, dri dynamic.ResourceInterface, obj *unstructured.Unstructured) (string, error) {
+	var opts metav1.DeleteOptions
+	//without a policy the server uses the default of the resource
+	if *deletePropagation != "" {
+		policy := metav1.DeletionPropagation(*deletePropagation)
+		opts.PropagationPolicy = &policy
+	}
+	//without a grace period the server uses the one of the object, e.g. terminationGracePeriodSeconds of pods
+	if *gracePeriod >= 0 {
+		seconds := int64(*gracePeriod)
+		opts.GracePeriodSeconds = &seconds
+	}
+
+	err := dri.Delete(ctx, obj.GetName(), opts)
+	if apierrors.IsNotFound(err) {
+		return resultNotFound, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return resultDeleted, nil
+}
+
+// objectRef formats an object like kubectl does, e.g. deployment.apps/nginx.
+func objectRef(mapping *meta.RESTMapping, obj *unstructured.Unstructured) string {
+	kind := strings.ToLower(obj.GetKind())
+	if mapping.Resource.Group != "" {
+		kind += "." + mapping.Resource.Group
+	}
+	return kind + "/" + obj.GetName()
+}
+
+// getClientset creates a Kubernetes client from the kubeconfig (or in-cluster config).
+func getClientset() (kubernetes.Interface, error) {
+	config, err := getRestConfig(getKubeConfig())
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// restMapping returns the REST mapping for the given group version kind, since we want to call REST API to apply manifest.
+// It builds a new REST mapper from the discovery API every time it is called.
+func restMapping(c kubernetes.Interface, gvk runtimeschema.GroupVersionKind) (*meta.RESTMapping, error) {
+	// Get the API group resources using the Kubernetes discovery API
+	//c is our kubernetes client
+	//get a mapping of API groups and the associated resources available in a Kubernetes cluster.
+	gr, err := restmapper.GetAPIGroupResources(c.Discovery())
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a REST mapper using the API group resources
+	//the gr variable contains info about the API group resources, we got this from above
+	mapper := restmapper.NewDiscoveryRESTMapper(gr)
+
+	// Get the REST mapping for the object's group version kind
+	// A REST mapper is responsible for mapping group-version-resource (GVR) identifiers to their corresponding REST endpoints.
+	return mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+}
+
+// restMappingForNewCRDs returns the REST mapping like restMapping. The kinds of the CRDs applied earlier in the
+// same manifest can be missing from discovery for a moment, for them the lookup is retried with a fresh mapper.
+func restMappingForNewCRDs(ctx context.Context, c kubernetes.Interface, gvk runtimeschema.GroupVersionKind, newKinds map[runtimeschema.GroupKind]bool) (*meta.RESTMapping, error) {
+	var mapping *meta.RESTMapping
+	err := retry.Do(ctx, crdEstablishBackoff(), func(ctx context.Context) error {
+		var err error
+		mapping, err = restMapping(c, gvk)
+		if err != nil && meta.IsNoMatchError(err) && newKinds[gvk.GroupKind()] {
+			log.Debugf("%s is not served yet, retrying: %v", gvk, err)
+			return retry.RetryableError(err)
+		}
+		return err
+	})
+	return mapping, err
+}
+
+// applyNewCRDObject retries applying a custom resource of a CRD of the same manifest which failed with
+// NotFound, applyErr, until the API server serves the resource or the retries are used up.
+func applyNewCRDObject(ctx context.Context, dri dynamic.ResourceInterface, obj *unstructured.Unstructured, applyErr error) (string, error) {
+	log.Debugf("%s/%s is not served yet, retrying: %v", obj.GetKind(), obj.GetName(), applyErr)
+	var result string
+	err := retry.Do(ctx, crdEstablishBackoff(), func(ctx context.Context) error {
+		var err error
+		//it didn't exist a moment ago, so there is no live object
+		result, err = applyObject(ctx, dri, obj, nil)
+		if apierrors.IsNotFound(err) {
+			return retry.RetryableError(err)
+		}
+		return err
+	})
+	return result, err
+}
+
+// createMissingNamespaces creates the namespaces the namespaced objects are applied to which don't exist yet.
+// Namespaces which are part of the manifest are left to the apply, they are applied first anyway. The created
+// namespaces are recorded for the undo command.
+func createMissingNamespaces(ctx context.Context, c kubernetes.Interface, objects []*unstructured.Unstructured, namespace string, undo *undoState) error {
+	gr, err := restmapper.GetAPIGroupResources(c.Discovery())
+	if err != nil {
+		return err
+	}
+	mapper := restmapper.NewDiscoveryRESTMapper(gr)
+
+	inManifest := map[string]bool{}
+	for _, obj := range objects {
+		if obj.GetKind() == "Namespace" && obj.GroupVersionKind().Group == "" {
+			inManifest[obj.GetName()] = true
+		}
+	}
+	var missing []string
+	seen := map[string]bool{}
+	for _, obj := range objects {
+		ns := obj.GetNamespace()
+		if ns == "" {
+			gvk := obj.GroupVersionKind()
+			//kinds we can't map yet, e.g. custom resources of a CRD in the manifest, are usually namespaced
+			if mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version); err == nil && mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+				continue
+			}
+			ns = namespace
+		}
+		if seen[ns] || inManifest[ns] {
+			continue
+		}
+		seen[ns] = true
+		missing = append(missing, ns)
+	}
+
+	for _, ns := range missing {
+		_, err := c.CoreV1().Namespaces().Get(ctx, ns, metav1.GetOptions{})
+		if err == nil {
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("unable to check namespace %s: %w", ns, err)
+		}
+		_, err = c.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}}, metav1.CreateOptions{})
+		//someone else created it in the meantime, it isn't ours to undo
+		if apierrors.IsAlreadyExists(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to create namespace %s: %w", ns, err)
+		}
+		fmt.Printf("namespace/%s %s\n", ns, resultCreated)
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("Namespace")
+		obj.SetName(ns)
+		undo.record(obj, nil)
+		if err := undo.save(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getNamespace returns the namespace used for namespaced objects that don't specify one.
+// It is the namespace flag if set, otherwise the context-namespace-default flag if set,
+// otherwise the namespace of the current context, otherwise default.
+func getNamespace(kubeConfig string) (string, error) {
+	namespace, _, err := resolveNamespace(kubeConfig)
+	return namespace, err
+}
+
+// selectedNamespace is the namespace picked by the user, so they are only asked once, e.g. for the diff and the apply
+var selectedNamespace string
+
+// selectNamespace returns the namespace used for the namespaced objects that don't specify one. If no namespace
+// is configured and we'd fall back to default, the user picks one of the existing namespaces in interactive runs.
+// Non-interactive runs, and manifests which don't need a namespace, keep the fallback.
+func selectNamespace(ctx context.Context, c kubernetes.Interface, kubeConfig string, objects []*unstructured.Unstructured) (string, error) {
+	namespace, fallback, err := resolveNamespace(kubeConfig)
+	if err != nil || !fallback || !*requireConfirmation || !promptTerminal() {
+		return namespace, err
+	}
+	if selectedNamespace != "" {
+		return selectedNamespace, nil
+	}
+
+	needsNamespace := false
+	for _, obj := range objects {
+		if obj.GetNamespace() != "" {
+			continue
+		}
+		mapping, err := restMapping(c, obj.GroupVersionKind())
+		if err == nil && mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			needsNamespace = true
+			break
+		}
+	}
+	if !needsNamespace {
+		return namespace, nil
+	}
+
+	list, err := c.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		//e.g. we may not be allowed to list namespaces, that shouldn't stop the apply
+		log.Debugf("unable to list namespaces: %v", err)
+		return namespace, nil
+	}
+	names := make([]string, 0, len(list.Items))
+	cursor := 0
+	for _, ns := range list.Items {
+		if ns.Name == namespace {
+			cursor = len(names)
+		}
+		names = append(names, ns.Name)
+	}
+	if len(names) == 0 {
+		return namespace, nil
+	}
+
+	prompt := promptui.Select{
+		Label:     "Select the namespace to apply to",
+		Items:     names,
+		Size:      10,
+		CursorPos: cursor,
+		Searcher: func(input string, index int) bool {
+			return strings.Contains(names[index], strings.ToLower(input))
+		},
+	}
+	_, result, err := prompt.Run()
+	if err != nil {
+		return "", err
+	}
+	selectedNamespace = result
+	return result, nil
+}
+
+// resolveNamespace returns the namespace of getNamespace and whether it's the default fallback,
+// because neither the flags, the in-cluster config nor the current context specify one.
+func resolveNamespace(kubeConfig string) (string, bool, error) {
+	//a different fallback than the context's namespace, without editing the kubeconfig
+	if *kubernetesConfigFlags.Namespace == "" && *contextNamespaceDefault != "" {
+		return *contextNamespaceDefault, false, nil
+	}
+
+	var namespace string
+	fallback := false
+	//we defined a variable kubernetesConfigFlags in root.go file to determine config flags for kubernetes
+	//if their namespace is not provided, then we get defaultNameSpace
+	if *kubernetesConfigFlags.Namespace == "" && *kubeServer != "" {
+		//there is no context to take the namespace from when the server is given directly
+		namespace = defaultNamespace
+		fallback = true
+	} else if *kubernetesConfigFlags.Namespace == "" && runningInCluster(kubeConfig) {
+		//inside a pod there is no kubeconfig, the namespace of the pod is mounted with the service account
+		namespace = inClusterNamespace()
+	} else if *kubernetesConfigFlags.Namespace == "" {
+		// If the namespace flag is not provided, retrieve the default namespace from the kubeConfig file
+		//call the getConfig function defined below
+		clientConfig, err := getConfig(kubeConfig)
+		if err != nil {
+			return "", false, err
+		}
+		//if even after getting kuubeConfig, in clientConfig, there's no namespace defined,
+		//use defaultNamespace
+		if clientConfig.Contexts[clientConfig.CurrentContext].Namespace == "" {
+			//defaultNameSpace constant is defined above in this file
+			namespace = defaultNamespace
+			fallback = true
+		} else {
+			namespace = clientConfig.Contexts[clientConfig.CurrentContext].Namespace
+		}
+	} else {
+		//else if configFlag's namespace has a value set, use that
+		// Use the provided namespace flag
+		namespace = *kubernetesConfigFlags.Namespace
+	}
+	return namespace, fallback, nil
+}
+
 // getKubeConfig returns the path to the Kubernetes configuration file.
 func getKubeConfig() string {
 	var kubeConfig string
@@ -197,6 +765,116 @@ func getKubeConfig() string {
 	return kubeConfig
 }
 
+// getRestConfig builds the rest config for the Kubernetes clients.
+// When there is no kubeconfig file but we are running inside a pod (e.g. as a Job),
+// the in-cluster config of the pod's service account is used instead.
+// With the kube-server flag the config is built from the server, token, insecure and certificate-authority flags only.
+func getRestConfig(kubeConfig string) (*rest.Config, error) {
+	config, err := buildRestConfig(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	//requests show up with this User-Agent in the audit log of the cluster
+	config.UserAgent = userAgent()
+	//offline runs apply to a local cluster only, see offline.go
+	if *noNetwork {
+		if err := checkLocalCluster(config); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// buildRestConfig builds the client configuration from the kube-server flag, the in-cluster config or the kubeconfig.
+func buildRestConfig(kubeConfig string) (*rest.Config, error) {
+	//quick one-offs against a remote cluster without a kubeconfig file. The token and CA file of kubectl's
+	//--token and --certificate-authority flags work too, e.g. for a server with a private CA
+	if *kubeServer != "" {
+		log.Debugf("using server %s from --kube-server", *kubeServer)
+		token := *kubeToken
+		if token == "" {
+			token = *kubernetesConfigFlags.BearerToken
+		}
+		return &rest.Config{
+			Host:        *kubeServer,
+			BearerToken: token,
+			TLSClientConfig: rest.TLSClientConfig{
+				Insecure: *kubeInsecure,
+				CAFile:   *kubernetesConfigFlags.CAFile,
+			},
+		}, nil
+	}
+	if runningInCluster(kubeConfig) {
+		log.Debugf("no kubeconfig found at %s, using in-cluster config", kubeConfig)
+		return rest.InClusterConfig()
+	}
+	return clientcmd.BuildConfigFromFlags("", kubeConfig)
+}
+
+// logRestConfig logs the server, context, namespace and auth method of the resolved config at debug level.
+// Only the kind of credentials is logged, never the credentials themselves.
+func logRestConfig(kubeConfig string, config *rest.Config, namespace string) {
+	if !log.IsLevelEnabled(log.DebugLevel) {
+		return
+	}
+	contextName := "none, using --kube-server"
+	if *kubeServer == "" && runningInCluster(kubeConfig) {
+		contextName = "none, using the in-cluster config"
+	} else if *kubeServer == "" {
+		name, err := getCurrentContextName()
+		if err != nil {
+			name = fmt.Sprintf("unknown (%v)", err)
+		}
+		contextName = fmt.Sprintf("%s from %s", name, kubeConfig)
+	}
+	log.Debugf("api server: %s", config.Host)
+	log.Debugf("context: %s", contextName)
+	log.Debugf("namespace: %s", namespace)
+	log.Debugf("auth method: %s", authMethod(config))
+	if config.Impersonate.UserName != "" {
+		log.Debugf("impersonating: %s", config.Impersonate.UserName)
+	}
+}
+
+// authMethod describes how the config authenticates to the API server, e.g. client certificate or exec plugin.
+func authMethod(config *rest.Config) string {
+	switch {
+	case config.ExecProvider != nil:
+		return fmt.Sprintf("exec plugin %s", config.ExecProvider.Command)
+	case config.AuthProvider != nil:
+		return fmt.Sprintf("auth provider %s", config.AuthProvider.Name)
+	case config.BearerTokenFile != "":
+		return fmt.Sprintf("bearer token from %s", config.BearerTokenFile)
+	case config.BearerToken != "":
+		return "bearer token"
+	case config.CertFile != "" || len(config.CertData) > 0:
+		return "client certificate"
+	case config.Username != "":
+		return fmt.Sprintf("basic auth as %s", config.Username)
+	default:
+		return "none"
+	}
+}
+
+// runningInCluster reports whether the kubeconfig file doesn't exist and we are running inside a pod.
+// Kubernetes sets the KUBERNETES_SERVICE_HOST environment variable in every container.
+func runningInCluster(kubeConfig string) bool {
+	if _, err := os.Stat(kubeConfig); !os.IsNotExist(err) {
+		return false
+	}
+	return os.Getenv("KUBERNETES_SERVICE_HOST") != ""
+}
+
+// inClusterNamespace returns the namespace of the pod we are running in, or the default namespace
+// if the service account namespace file can't be read.
+func inClusterNamespace() string {
+	ns, err := os.ReadFile(inClusterNamespaceFile)
+	if err != nil || strings.TrimSpace(string(ns)) == "" {
+		return defaultNamespace
+	}
+	return strings.TrimSpace(string(ns))
+}
+
 // getConfig retrieves the Kubernetes configuration from the specified kubeConfig file.
 func getConfig(kubeConfig string) (api.Config, error) {
 	// Create a new NonInteractiveDeferredLoadingClientConfig with the specified kubeConfig file path.
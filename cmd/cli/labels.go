@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// label-merge policies for required labels which an object already defines with a different value
+const (
+	labelMergeFail      = "fail"
+	labelMergeOverwrite = "overwrite"
+	labelMergeKeep      = "keep"
+)
+
+// requiredLabelPaths are the label maps the required labels are added to. Selectors are left alone,
+// adding to a selector would change which pods it selects and is immutable for most workloads.
+var requiredLabelPaths = [][]string{
+	{"metadata", "labels"},
+	{"spec", "template", "metadata", "labels"},
+	{"spec", "jobTemplate", "spec", "template", "metadata", "labels"},
+}
+
+// addRequiredLabels adds the labels of the labels flag to every object and the pod templates in it.
+// A label the object already has with a different value is handled with the label-merge policy:
+// fail returns an error, overwrite replaces the value and keep leaves the object's value.
+func addRequiredLabels(objects []*unstructured.Unstructured) error {
+	if len(*requiredLabels) == 0 {
+		return nil
+	}
+	//sorted so conflicts are always reported in the same order
+	keys := make([]string, 0, len(*requiredLabels))
+	for k := range *requiredLabels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, obj := range objects {
+		for i, path := range requiredLabelPaths {
+			labels, found, err := unstructured.NestedStringMap(obj.Object, path...)
+			if err != nil {
+				return fmt.Errorf("unable to read %s of %s/%s: %w", strings.Join(path, "."), obj.GetKind(), obj.GetName(), err)
+			}
+			//only objects with a pod template get the labels on it, metadata.labels always
+			if !found && i > 0 && !hasField(obj.Object, path[:len(path)-2]...) {
+				continue
+			}
+			if labels == nil {
+				labels = map[string]string{}
+			}
+			for _, k := range keys {
+				v := (*requiredLabels)[k]
+				existing, ok := labels[k]
+				if ok && existing != v {
+					switch *labelMerge {
+					case labelMergeFail:
+						return fmt.Errorf("%s/%s: %s already has the label %s=%s, required is %s=%s, set --label-merge to overwrite or keep", obj.GetKind(), obj.GetName(), strings.Join(path, "."), k, existing, k, v)
+					case labelMergeKeep:
+						log.Debugf("%s/%s: keeping %s %s=%s", obj.GetKind(), obj.GetName(), strings.Join(path, "."), k, existing)
+						continue
+					}
+				}
+				labels[k] = v
+			}
+			if err := unstructured.SetNestedStringMap(obj.Object, labels, path...); err != nil {
+				return fmt.Errorf("unable to set %s of %s/%s: %w", strings.Join(path, "."), obj.GetKind(), obj.GetName(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// hasField reports whether the object has a map at the path, e.g. spec.template.
+func hasField(obj map[string]interface{}, path ...string) bool {
+	_, found, err := unstructured.NestedMap(obj, path...)
+	return found && err == nil
+}
@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// podSpecPaths are the paths to the pod spec of the workload kinds the linter checks
+var podSpecPaths = map[string][]string{
+	"Pod":         {"spec"},
+	"Deployment":  {"spec", "template", "spec"},
+	"StatefulSet": {"spec", "template", "spec"},
+	"DaemonSet":   {"spec", "template", "spec"},
+	"ReplicaSet":  {"spec", "template", "spec"},
+	"Job":         {"spec", "template", "spec"},
+	"CronJob":     {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// lintManifest checks the workloads of the manifest for best practices the schema doesn't enforce:
+// probes, resource limits and a non-root security context. It returns a warning per finding like
+// "Deployment/nginx: container nginx has no readinessProbe".
+func lintManifest(completion string) ([]string, error) {
+	objects, err := decodeManifest(completion)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	for _, obj := range objects {
+		for _, warning := range lintObject(obj) {
+			warnings = append(warnings, fmt.Sprintf("%s/%s: %s", obj.GetKind(), obj.GetName(), warning))
+		}
+	}
+	return warnings, nil
+}
+
+// lintObject returns the findings for a single object, objects which aren't workloads have none.
+func lintObject(obj *unstructured.Unstructured) []string {
+	path, ok := podSpecPaths[obj.GetKind()]
+	if !ok {
+		return nil
+	}
+	podSpec, found, _ := unstructured.NestedMap(obj.Object, path...)
+	if !found {
+		return nil
+	}
+	return lintPodSpec(obj.GetKind(), podSpec)
+}
+
+// lintPodSpec returns the findings for the containers of a pod spec.
+func lintPodSpec(kind string, podSpec map[string]interface{}) []string {
+	podRunAsNonRoot, _, _ := unstructured.NestedBool(podSpec, "securityContext", "runAsNonRoot")
+	containers, _, _ := unstructured.NestedSlice(podSpec, "containers")
+
+	var warnings []string
+	for _, c := range containers {
+		container, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(container, "name")
+		//jobs run to completion, they aren't expected to have probes
+		if kind != "Job" && kind != "CronJob" {
+			for _, probe := range []string{"livenessProbe", "readinessProbe"} {
+				if _, found := container[probe]; !found {
+					warnings = append(warnings, fmt.Sprintf("container %s has no %s", name, probe))
+				}
+			}
+		}
+		if limits, _, _ := unstructured.NestedMap(container, "resources", "limits"); len(limits) == 0 {
+			warnings = append(warnings, fmt.Sprintf("container %s has no resource limits", name))
+		}
+		runAsNonRoot, found, _ := unstructured.NestedBool(container, "securityContext", "runAsNonRoot")
+		if !runAsNonRoot && (found || !podRunAsNonRoot) {
+			warnings = append(warnings, fmt.Sprintf("container %s doesn't set runAsNonRoot in its security context", name))
+		}
+	}
+	return warnings
+}
+
+// warnLintFindings prints the findings of lintManifest, the lint is advisory and never stops the apply.
+func warnLintFindings(completion string) {
+	warnings, err := lintManifest(completion)
+	if err != nil {
+		log.Debugf("unable to lint manifest: %v", err)
+		return
+	}
+	for _, warning := range warnings {
+		log.Warnf("lint: %s", warning)
+	}
+}
@@ -0,0 +1,392 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8syaml "k8s.io/apimachinery/pkg/runtime/serializer/yaml"
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// decodeManifest decodes every document of the manifest into an unstructured object.
+// Decoding stops at the first document that can't be decoded, with the strict-yaml flag
+// that is an error instead so a garbled manifest isn't quietly truncated.
+func decodeManifest(completion string) ([]*unstructured.Unstructured, error) {
+	//models sometimes use anchors and merge keys, expand them into plain maps first
+	//so every document applies predictably. If the manifest can't be parsed we leave it
+	//as is and let the decoder below deal with it
+	if expanded, err := expandYAMLAnchors(completion); err == nil {
+		completion = expanded
+	} else {
+		log.Debugf("unable to expand YAML anchors: %v", err)
+	}
+
+	// Convert the completion string to a byte array
+	manifest := []byte(completion)
+
+	// Create a YAML or JSON decoder to decode the manifest
+	//note we are using YAMLorJSONDecoder, meaning we are prepared for both data types
+	decoder := yamlutil.NewYAMLOrJSONDecoder(bytes.NewReader(manifest), 100)
+
+	var objects []*unstructured.Unstructured
+	// Decode each object in the manifest
+	for i := 1; ; i++ {
+		//runtime.RawExtension is a type provided by the Kubernetes client libraries.
+		//It is used to represent arbitrary JSON or yaml data without unmarshaling it into a specific struct.
+		//This can be useful in situations where you want to work with Kubernetes resources that have dynamic or unknown structures.
+		var rawObj runtime.RawExtension
+		//decoder already has the manifest file, we want to structure it like rawObj
+		//and decode it into the rawObj variable, since we don't know the structure of the JSON data
+		//at compile time, so need RawExtension, we will further process rawObj now
+		if err := decoder.Decode(&rawObj); err != nil {
+			if *strictYAML && !errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("unable to decode document %d of the manifest: %w", i, err)
+			}
+			break
+		}
+
+		// Decode the raw object into a typed object using the YAML decoding serializer
+		//here obj is the decoded object for data that was stored in rawObj
+		//we basically created a new yaml decodingSerializer to process JSON data into something golang understands
+		obj, _, err := k8syaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme).Decode(rawObj.Raw, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode document %d of the manifest: %w", i, err)
+		}
+
+		// Convert the strongly typed object that golang understands to an unstructured map
+		//so that we can process it further
+		unstructuredMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return nil, err
+		}
+
+		//we now have an unstructured map and need an unstructured object from it
+		// Create an unstructured object from the unstructured map
+		//models sometimes wrap the objects in a List, which can't be applied itself
+		listed, err := unwrapList(&unstructured.Unstructured{Object: unstructuredMap})
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode document %d of the manifest: %w", i, err)
+		}
+		objects = append(objects, listed...)
+	}
+
+	return objects, nil
+}
+
+// encodeManifest encodes the objects into a manifest with a document per object, e.g. after they were changed
+// to fix the model's output.
+func encodeManifest(objects []*unstructured.Unstructured) (string, error) {
+	var docs []string
+	for _, obj := range objects {
+		doc, err := sigsyaml.Marshal(obj.Object)
+		if err != nil {
+			return "", err
+		}
+		docs = append(docs, string(doc))
+	}
+	return strings.Join(docs, "---\n"), nil
+}
+
+// fieldPath returns the path of the fields below path as a new slice, appending to path itself could change
+// a shared slice like the paths of podSpecPaths.
+func fieldPath(path []string, fields ...string) []string {
+	return append(append([]string{}, path...), fields...)
+}
+
+// unwrapList returns the items of a List, like kind: List or kind: ConfigMapList, including the items of
+// nested Lists. Any other object is returned as it is.
+func unwrapList(obj *unstructured.Unstructured) ([]*unstructured.Unstructured, error) {
+	if !obj.IsList() {
+		return []*unstructured.Unstructured{obj}, nil
+	}
+	var objects []*unstructured.Unstructured
+	err := obj.EachListItem(func(item runtime.Object) error {
+		listed, err := unwrapList(item.(*unstructured.Unstructured))
+		objects = append(objects, listed...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("unwrapped %d objects of %s", len(objects), obj.GetKind())
+	return objects, nil
+}
+
+// checkManifestSize returns an error if the completion is larger than the max-manifest-bytes flag, so a runaway
+// generation is neither decoded nor applied. A limit of 0 turns the check off.
+func checkManifestSize(completion string) error {
+	if *maxManifestBytes > 0 && len(completion) > *maxManifestBytes {
+		return fmt.Errorf("the generated manifest has %d bytes, more than the --max-manifest-bytes of %d", len(completion), *maxManifestBytes)
+	}
+	return nil
+}
+
+// manifestSummary returns a line per object of the manifest like "Deployment/nginx (namespace: web)",
+// with the names which are applied. The namespace is only shown for objects which specify one.
+func manifestSummary(completion string) (string, error) {
+	objects, err := decodeManifest(completion)
+	if err != nil {
+		return "", err
+	}
+	objects, err = filterObjects(objects, *only)
+	if err != nil {
+		return "", err
+	}
+	renameObjects(objects)
+
+	var summary strings.Builder
+	for _, obj := range objects {
+		fmt.Fprintf(&summary, "- %s/%s", obj.GetKind(), obj.GetName())
+		if obj.GetNamespace() != "" {
+			fmt.Fprintf(&summary, " (namespace: %s)", obj.GetNamespace())
+		}
+		summary.WriteString("\n")
+	}
+	return summary.String(), nil
+}
+
+// annotations added to applied objects with the annotate flag
+const (
+	promptAnnotation       = "assistant.io/prompt"
+	promptSHA256Annotation = "assistant.io/prompt-sha256"
+	modelAnnotation        = "assistant.io/model"
+	// maxPromptAnnotationLength keeps long prompts from bloating every object
+	maxPromptAnnotationLength = 256
+)
+
+// generatedAnnotations returns the annotations which record the prompt and the model that generated a manifest.
+// The prompt is truncated, its hash identifies the complete prompt.
+func generatedAnnotations(prompt string, info completionInfo) map[string]string {
+	sum := sha256.Sum256([]byte(prompt))
+	truncated := prompt
+	//truncated on a character, cutting a multi-byte character in half would make the annotation invalid UTF-8
+	if runes := []rune(truncated); len(runes) > maxPromptAnnotationLength {
+		truncated = string(runes[:maxPromptAnnotationLength]) + "..."
+	}
+	annotations := map[string]string{
+		promptAnnotation:       truncated,
+		promptSHA256Annotation: hex.EncodeToString(sum[:]),
+	}
+	if info.Model != "" {
+		annotations[modelAnnotation] = info.Model
+	}
+	return annotations
+}
+
+// addAnnotations adds the annotations to the object, keeping the annotations it already has.
+func addAnnotations(obj *unstructured.Unstructured, annotations map[string]string) {
+	if len(annotations) == 0 {
+		return
+	}
+	existing := obj.GetAnnotations()
+	if existing == nil {
+		existing = map[string]string{}
+	}
+	for k, v := range annotations {
+		existing[k] = v
+	}
+	obj.SetAnnotations(existing)
+}
+
+// filterObjects returns the objects matching one of the kind/name selectors of the only flag,
+// e.g. configmap/nginx-config. The kind is case-insensitive and * matches every name of a kind.
+// All objects are returned if no selectors are given.
+func filterObjects(objects []*unstructured.Unstructured, selectors []string) ([]*unstructured.Unstructured, error) {
+	if len(selectors) == 0 {
+		return objects, nil
+	}
+
+	var filtered []*unstructured.Unstructured
+	for _, obj := range objects {
+		for _, selector := range selectors {
+			kind, name, ok := strings.Cut(selector, "/")
+			if !ok {
+				return nil, fmt.Errorf("invalid --only selector %q, expected kind/name", selector)
+			}
+			if strings.EqualFold(kind, obj.GetKind()) && (name == "*" || name == obj.GetName()) {
+				filtered = append(filtered, obj)
+				break
+			}
+		}
+	}
+	log.Debugf("applying %d of %d objects matching %v", len(filtered), len(objects), selectors)
+	return filtered, nil
+}
+
+// applyOrder is the position of well-known kinds when applying a manifest, so the objects others refer to exist
+// first: namespaces, definitions and classes, configuration and storage, RBAC, workloads and then networking.
+// Other kinds, e.g. custom resources, are applied last, after their CRDs.
+var applyOrder = map[string]int{
+	"Namespace":                0,
+	"CustomResourceDefinition": 1,
+	"StorageClass":             1,
+	"PriorityClass":            1,
+	"ServiceAccount":           2,
+	"ConfigMap":                2,
+	"Secret":                   2,
+	"PersistentVolume":         2,
+	"PersistentVolumeClaim":    2,
+	"LimitRange":               2,
+	"ResourceQuota":            2,
+	"ClusterRole":              3,
+	"ClusterRoleBinding":       3,
+	"Role":                     3,
+	"RoleBinding":              3,
+	"Deployment":               4,
+	"StatefulSet":              4,
+	"DaemonSet":                4,
+	"ReplicaSet":               4,
+	"Job":                      4,
+	"CronJob":                  4,
+	"Pod":                      4,
+	"HorizontalPodAutoscaler":  4,
+	"PodDisruptionBudget":      4,
+	"Service":                  5,
+	"Ingress":                  5,
+	"NetworkPolicy":            5,
+}
+
+// otherKindsPosition is the position of kinds missing from applyOrder
+const otherKindsPosition = 6
+
+// applyWeightAnnotation orders the objects of a manifest explicitly, like the hook weights of Helm.
+// Objects with a lower weight are applied first, objects without the annotation have the weight 0.
+const applyWeightAnnotation = "assistant.io/apply-weight"
+
+// orderObjects sorts the objects by their apply-weight annotation and then in apply order of their kind,
+// objects of the same weight and position keep the order of the manifest. Without annotations this is
+// the order of applyOrder.
+func orderObjects(objects []*unstructured.Unstructured) {
+	position := func(obj *unstructured.Unstructured) int {
+		if p, ok := applyOrder[obj.GetKind()]; ok {
+			return p
+		}
+		return otherKindsPosition
+	}
+	weights := make(map[*unstructured.Unstructured]int, len(objects))
+	for _, obj := range objects {
+		weights[obj] = applyWeight(obj)
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		if weights[objects[i]] != weights[objects[j]] {
+			return weights[objects[i]] < weights[objects[j]]
+		}
+		return position(objects[i]) < position(objects[j])
+	})
+}
+
+// applyWeight returns the weight of the apply-weight annotation of the object, 0 if it has none.
+// A weight which isn't an integer is ignored with a warning.
+func applyWeight(obj *unstructured.Unstructured) int {
+	value, ok := obj.GetAnnotations()[applyWeightAnnotation]
+	if !ok {
+		return 0
+	}
+	weight, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		log.Warnf("%s/%s has the %s %q which is not an integer, ignoring it", obj.GetKind(), obj.GetName(), applyWeightAnnotation, value)
+		return 0
+	}
+	return weight
+}
+
+// warnMissingProductionResources warns if the manifest contains a Deployment but no
+// PodDisruptionBudget or HorizontalPodAutoscaler, which the production flag asks for.
+func warnMissingProductionResources(completion string) {
+	objects, err := decodeManifest(completion)
+	if err != nil {
+		return
+	}
+	kinds := map[string]bool{}
+	for _, obj := range objects {
+		kinds[obj.GetKind()] = true
+	}
+	if !kinds["Deployment"] {
+		return
+	}
+	for _, kind := range []string{"PodDisruptionBudget", "HorizontalPodAutoscaler"} {
+		if !kinds[kind] {
+			log.Warnf("the generated manifest contains a Deployment but no %s, reprompt to add it", kind)
+		}
+	}
+}
+
+// expandYAMLAnchors expands YAML anchors, aliases and merge keys (<<) in every document of the
+// manifest into plain maps, so the decoder in applyManifest always sees fully resolved objects.
+// Manifests without any anchors are returned unchanged.
+func expandYAMLAnchors(manifest string) (string, error) {
+	//first pass, parse every document into a yaml node so we can check for anchors
+	var docs []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader([]byte(manifest)))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return "", err
+		}
+		docs = append(docs, &doc)
+	}
+
+	usesAnchors := false
+	for _, doc := range docs {
+		if hasAnchors(doc) {
+			usesAnchors = true
+			break
+		}
+	}
+	//nothing to expand, keep the model's formatting as is
+	if !usesAnchors {
+		return manifest, nil
+	}
+
+	//second pass, decoding a node into an interface{} resolves aliases and merge keys,
+	//encoding it again gives us plain YAML without any anchors
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	for _, doc := range docs {
+		var obj interface{}
+		if err := doc.Decode(&obj); err != nil {
+			return "", err
+		}
+		//skip empty documents, e.g. a leading ---
+		if obj == nil {
+			continue
+		}
+		if err := encoder.Encode(obj); err != nil {
+			return "", err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
+
+// hasAnchors reports whether the node or any of its children define an anchor or use an alias.
+func hasAnchors(node *yaml.Node) bool {
+	if node.Anchor != "" || node.Kind == yaml.AliasNode {
+		return true
+	}
+	for _, child := range node.Content {
+		if hasAnchors(child) {
+			return true
+		}
+	}
+	return false
+}
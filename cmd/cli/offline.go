@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/rest"
+)
+
+// errNoNetwork is returned instead of calling the model with the no-network flag
+var errNoNetwork = errors.New("no cached response for the request and --no-network doesn't call the model, generate the manifest once with --cache while online")
+
+// resolveNoNetwork checks that every source a run with the no-network flag needs is offline and fails with
+// the missing artifact otherwise: the schema has to come from a local file and the manifests from the
+// response cache, which is read without the cache flag too. The cluster is checked in getRestConfig.
+func resolveNoNetwork() error {
+	if !*noNetwork {
+		return nil
+	}
+	//the schema is downloaded from the cluster or the k8s-openapi-url by these flags
+	if *usek8sAPI || *validateReport || *schemaFix {
+		if *k8sOpenAPIURL == "" {
+			return fmt.Errorf("--no-network requires --k8s-openapi-url with a local schema file, e.g. --k8s-openapi-url=./openapi.json")
+		}
+	}
+	if *k8sOpenAPIURL != "" {
+		path, ok := schemaFile(*k8sOpenAPIURL)
+		if !ok {
+			return fmt.Errorf("--no-network requires --k8s-openapi-url to be a local schema file, got %s", *k8sOpenAPIURL)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("--no-network requires the schema file of --k8s-openapi-url: %w", err)
+		}
+	}
+	if *targetedSchema {
+		return fmt.Errorf("--targeted-schema can't be used together with --no-network, it downloads the schema")
+	}
+	if *noCache {
+		return fmt.Errorf("--no-cache can't be used together with --no-network, the manifests come from the response cache")
+	}
+	//these call the model or other services, e.g. to check the endpoint or after the manifest was generated
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"--explain-diff", *explainDiffFlag},
+		{"--validate-endpoint", *validateEndpoint},
+		{"--pin-images", *pinImagesFlag},
+		{"--approval-webhook", *approvalWebhook != ""},
+		{"--otel-endpoint", *otelEndpoint != ""},
+	} {
+		if f.set {
+			return fmt.Errorf("%s can't be used together with --no-network, it needs network access", f.name)
+		}
+	}
+	*cache = true
+	return nil
+}
+
+// checkLocalCluster returns an error unless the API server of the config is on this machine or in a private
+// network, e.g. a kind cluster on 127.0.0.1 or a minikube VM. Host names are resolved, /etc/hosts works offline.
+func checkLocalCluster(config *rest.Config) error {
+	u, err := url.Parse(config.Host)
+	if err != nil || u.Host == "" {
+		//rest.Config accepts a host without a scheme
+		u, err = url.Parse("https://" + config.Host)
+		if err != nil {
+			return fmt.Errorf("--no-network can't check the API server %s: %w", config.Host, err)
+		}
+	}
+	host := u.Hostname()
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return fmt.Errorf("--no-network requires a local cluster, unable to resolve the API server %s: %w", host, err)
+	}
+	for _, a := range addrs {
+		ip, err := netip.ParseAddr(a)
+		if err != nil || !(ip.IsLoopback() || ip.IsPrivate()) {
+			return fmt.Errorf("--no-network requires a local cluster, the API server %s is at %s", host, a)
+		}
+	}
+	log.Debugf("using the local cluster at %s", config.Host)
+	return nil
+}
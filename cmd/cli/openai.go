@@ -17,12 +17,22 @@ const (
 	fnCallNone functionCallType = "none"
 )
 
+// completionInfo describes which model produced a completion, so every manifest is traceable
+// to a specific model revision in the audit log and the JSON output
+type completionInfo struct {
+	Model             string `json:"model"`
+	SystemFingerprint string `json:"systemFingerprint,omitempty"`
+	// PromptTokens and CompletionTokens are the token usage of all requests of the generation, 0 if the API didn't report it
+	PromptTokens     int `json:"promptTokens,omitempty"`
+	CompletionTokens int `json:"completionTokens,omitempty"`
+}
+
 //if you want to use open AI chat models, you have to use chat completion function
 //it takes multpiple messages (or a complete dialogue) and not just a prompt
 
 // openaiGptCompletion is a function that sends a completion request to the OpenAI GPT-3 API
 // and returns the generated text based on the provided prompt.
-func (c *oaiClients) openaiGptCompletion(ctx context.Context, prompt *strings.Builder, temp float32) (string, error) {
+func (c *oaiClients) openaiGptCompletion(ctx context.Context, prompt *strings.Builder, temp float32) (string, completionInfo, error) {
 	// Create a completion request with the provided prompt and temperature
 	req := openai.CompletionRequest{
 		Prompt:      []string{prompt.String()},
@@ -42,23 +52,24 @@ func (c *oaiClients) openaiGptCompletion(ctx context.Context, prompt *strings.Bu
 	resp, err := c.openAIClient.CreateCompletion(ctx, req)
 	//handling the error from the chatgpt request
 	if err != nil {
-		return "", err
+		return "", completionInfo{}, err
 	}
 
 	// Check if the response contains exactly one choice
 	//if you select n more than 1, you will get more choices
 	if len(resp.Choices) != 1 {
-		return "", fmt.Errorf("expected choices to be 1 but received: %d", len(resp.Choices))
+		return "", completionInfo{}, fmt.Errorf("expected choices to be 1 but received: %d", len(resp.Choices))
 	}
 
 	// Return the generated text from the response
 	//the first choice from the response is what we want to return from here
-	return resp.Choices[0].Text, nil
+	//the completions API doesn't return a system fingerprint, only the model
+	return resp.Choices[0].Text, completionInfo{Model: resp.Model, PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens}, nil
 }
 
 // openaiGptChatCompletion is a function that performs chat completion using OpenAI GPT model.
 // It takes a context, a prompt, and a temperature as input and returns the completed chat response or an error.
-func (c *oaiClients) openaiGptChatCompletion(ctx context.Context, prompt *strings.Builder, temp float32) (string, error) {
+func (c *oaiClients) openaiGptChatCompletion(ctx context.Context, prompt *strings.Builder, temp float32) (string, completionInfo, error) {
 	//defining some variables to work with request, response etc.
 	var (
 		resp     openai.ChatCompletionResponse
@@ -71,11 +82,23 @@ func (c *oaiClients) openaiGptChatCompletion(ctx context.Context, prompt *string
 	// Determine the type of function call based on whether the k8s API is being used or not.
 	fnCallType := fnCallAuto
 	//if K8sAPI is not being used (i.e the flag is false)
-	if !*usek8sAPI {
+	//with no-functions the prompt still asks for up-to-date specs, but the model can't call the functions
+	if !*usek8sAPI || *noFunctions {
 		//then function call will be of type None
 		fnCallType = fnCallNone
+	} else {
+		//the first schema is fetched while the model works on the prompt, the cached schemas are reset by the
+		//caller, concurrent conversations of a batch share them
+		prefetchSchemas()
+	}
+
+	//the images of the image flag are sent with every request of the conversation, see image.go
+	images, err := imageParts()
+	if err != nil {
+		return "", completionInfo{}, err
 	}
 
+	var promptTokens, completionTokens int
 	for {
 		// Append the content to the prompt.
 		prompt.WriteString(content)
@@ -105,11 +128,28 @@ func (c *oaiClients) openaiGptChatCompletion(ctx context.Context, prompt *string
 			FunctionCall: fnCallType,
 		}
 //calling the API's function CreateChatCompltion by passing the request object
+		//don't even offer the functions with no-functions, some models call them anyway
+		if *noFunctions {
+			req.Functions, req.FunctionCall = nil, nil
+		}
+		//vision models get the prompt and the images as parts of the message instead, a message can't have both
+		if len(images) > 0 {
+			req.Messages[0].Content = ""
+			req.Messages[0].MultiContent = append([]openai.ChatMessagePart{{Type: openai.ChatMessagePartTypeText, Text: prompt.String()}}, images...)
+		}
 		// Call the OpenAI API to get the chat completion response.
-		resp, err = c.openAIClient.CreateChatCompletion(ctx, req)
+		//with the stream flag the manifest is shown while it is generated, see stream.go
+		if *stream {
+			resp, err = c.streamChatCompletion(ctx, req)
+		} else {
+			resp, err = c.openAIClient.CreateChatCompletion(ctx, req)
+		}
 		if err != nil {
-			return "", err
+			return "", completionInfo{}, err
 		}
+		//every function call is another request, the usage of the generation is the sum of them
+		promptTokens += resp.Usage.PromptTokens
+		completionTokens += resp.Usage.CompletionTokens
 //the response has FunctionCall data and we'll extract that in funcName variable
 //defined with the variables earlier in this function
 		funcName = resp.Choices[0].Message.FunctionCall
@@ -127,13 +167,13 @@ func (c *oaiClients) openaiGptChatCompletion(ctx context.Context, prompt *string
 		//funcCall function is also defined in functions.go
 		content, err = funcCall(funcName)
 		if err != nil {
-			return "", err
+			return "", completionInfo{}, err
 		}
 	}
 //if length is more than 1, we will send an error just like the previous function
 //this usually happens is n is set to be more than 1, open ai returns more options
 	if len(resp.Choices) != 1 {
-		return "", fmt.Errorf("expected choices to be 1 but received: %d", len(resp.Choices))
+		return "", completionInfo{}, fmt.Errorf("expected choices to be 1 but received: %d", len(resp.Choices))
 	}
 //select the content of the first choice in the response and capture that in result
 	result := resp.Choices[0].Message.Content
@@ -144,7 +184,11 @@ func (c *oaiClients) openaiGptChatCompletion(ctx context.Context, prompt *string
 	//the trim ticks function is mentioned below, for working with yaml files
 	result = trimTicks(result)
 
-	return result, nil
+	//the model and system fingerprint identify the exact model revision which produced the manifest
+	info := completionInfo{Model: resp.Model, SystemFingerprint: resp.SystemFingerprint, PromptTokens: promptTokens, CompletionTokens: completionTokens}
+	log.Debugf("model: %s, system fingerprint: %s", info.Model, info.SystemFingerprint)
+
+	return result, info, nil
 }
 
 // trimTicks removes the tick marks from a given string.
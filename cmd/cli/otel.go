@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// otelTracesPath is where OTLP/HTTP collectors receive spans, added to an otel-endpoint without a path
+const otelTracesPath = "/v1/traces"
+
+// otelShutdownTimeout bounds flushing the spans when we exit, an unreachable collector shouldn't hang the CLI
+const otelShutdownTimeout = 5 * time.Second
+
+// maxSpanContent is how much of the prompt and the manifest is added to a span, collectors reject huge attributes
+const maxSpanContent = 8 * 1024
+
+// tracer creates the spans of generations and applies. Until startTelemetry installs a tracer provider
+// the global provider is a no-op, so without otel-endpoint spans cost nothing.
+var tracer = otel.Tracer("github.com/akhilsharma90/kubectl-assistant")
+
+// otelTracesURL returns the URL spans are exported to, the otel-endpoint with otelTracesPath if it has no path.
+func otelTracesURL(endpoint string) (*url.URL, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("expected an http or https URL like http://localhost:4318")
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otelTracesPath
+	}
+	return u, nil
+}
+
+// startTelemetry exports the spans to the otel-endpoint with OTLP over HTTP. The returned function flushes
+// and stops the export, it is called when the command is done. Failing to set up the export only logs a
+// warning, telemetry never stops a generation or an apply.
+func startTelemetry() func() {
+	if *otelEndpoint == "" {
+		return func() {}
+	}
+	u, err := otelTracesURL(*otelEndpoint)
+	if err != nil {
+		log.Warnf("unable to export telemetry: %v", err)
+		return func() {}
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host), otlptracehttp.WithURLPath(u.Path)}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		log.Warnf("unable to export telemetry: %v", err)
+		return func() {}
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("kubectl-assistant"),
+		semconv.ServiceVersion(version),
+	))
+	if err != nil {
+		log.Debugf("unable to merge telemetry resource: %v", err)
+		res = resource.Default()
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	log.Debugf("exporting telemetry to %s", u)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), otelShutdownTimeout)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			log.Warnf("unable to export telemetry: %v", err)
+		}
+	}
+}
+
+// startSpan starts a span of a run, with the trace id of the run so spans and log lines can be matched.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if traceID != "" {
+		attrs = append(attrs, attribute.String("kubectl_assistant.trace_id", traceID))
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span with the outcome, a failed outcome records the error too.
+func endSpan(span trace.Span, outcome string, err error) {
+	span.SetAttributes(attribute.String("kubectl_assistant.outcome", outcome))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// outcomeOf returns the outcome of a span, success if there was no error.
+func outcomeOf(err error, success string) string {
+	if err != nil {
+		return "failed"
+	}
+	return success
+}
+
+// spanContent shortens the prompt or manifest for a span attribute.
+func spanContent(content string) string {
+	if len(content) <= maxSpanContent {
+		return content
+	}
+	//cut before a character, half of a multi-byte character is invalid UTF-8 and fails the export of the spans
+	end := maxSpanContent
+	for end > 0 && !utf8.RuneStart(content[end]) {
+		end--
+	}
+	return content[:end] + "..."
+}
+
+// generationAttributes are the attributes of a finished generation: the model, its token usage and the
+// number of documents of the manifest.
+func generationAttributes(completion string, info completionInfo) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("gen_ai.response.model", info.Model),
+		attribute.Int("gen_ai.usage.input_tokens", info.PromptTokens),
+		attribute.Int("gen_ai.usage.output_tokens", info.CompletionTokens),
+		attribute.Int("kubectl_assistant.objects", countDocuments(completion)),
+		attribute.String("kubectl_assistant.completion", spanContent(strings.TrimSpace(completion))),
+	}
+}
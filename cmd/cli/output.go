@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// outputJSON is the output flag value for machine-readable JSON output
+const outputJSON = "json"
+
+// jsonOutput is printed with output=json
+type jsonOutput struct {
+	Manifest string `json:"manifest"`
+	TraceID  string `json:"traceID,omitempty"`
+	completionInfo
+}
+
+// printJSONOutput prints the manifest together with the model that generated it as JSON.
+func printJSONOutput(completion string, info completionInfo) error {
+	out, err := json.MarshalIndent(jsonOutput{Manifest: completion, TraceID: traceID, completionInfo: info}, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// writeOutput writes the generated manifest to the output-file, or with the split flag
+// every document to its own file in the output-file directory.
+func writeOutput(completion string) error {
+	header, err := manifestHeader()
+	if err != nil {
+		return err
+	}
+	if *split {
+		return writeSplitManifest(*outputFile, header, completion)
+	}
+	if err := os.WriteFile(*outputFile, []byte(header+completion), 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("%sWrote manifest to %s\n", emoji("✨"), *outputFile)
+	return nil
+}
+
+// manifestHeaderDate is replaced with the current date in the manifest-header
+const manifestHeaderDate = "{{date}}"
+
+// manifestHeader returns the comment block of the manifest-header flag which goes on top of every written file.
+// The flag is either the text itself or @ followed by the path of a file with the text. Lines which aren't
+// comments yet are turned into comments, so the header never changes what is applied from the file.
+func manifestHeader() (string, error) {
+	text := *outputHeader
+	if path, ok := strings.CutPrefix(text, "@"); ok {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("unable to read manifest header: %w", err)
+		}
+		text = string(content)
+	}
+	text = strings.TrimSpace(strings.ReplaceAll(text, manifestHeaderDate, time.Now().UTC().Format(time.DateOnly)))
+	if text == "" {
+		return "", nil
+	}
+
+	var header strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if !strings.HasPrefix(line, "#") {
+			line = strings.TrimSpace("# " + line)
+		}
+		header.WriteString(line + "\n")
+	}
+	return header.String(), nil
+}
+
+// writeSplitManifest writes every document of the manifest to its own <kind>-<name>.yaml file in dir, each
+// starting with the header. The filenames are derived from the decoded objects, so the directory is created
+// if it doesn't exist.
+func writeSplitManifest(dir, header, completion string) error {
+	objects, err := decodeManifest(completion)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	written := map[string]bool{}
+	for _, obj := range objects {
+		//re-encode the decoded object, it is the same content the apply would use
+		content, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return err
+		}
+
+		name := fmt.Sprintf("%s-%s.yaml", strings.ToLower(obj.GetKind()), obj.GetName())
+		//the model decides the kind and name, the files must stay inside dir
+		if strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+			return fmt.Errorf("the name of %s/%s can't be used as a filename", obj.GetKind(), obj.GetName())
+		}
+		path := filepath.Join(dir, name)
+		if rel, err := filepath.Rel(dir, path); err != nil || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("the generated %s/%s would be written outside of %s", obj.GetKind(), obj.GetName(), dir)
+		}
+		//two documents with the same kind and name (e.g. in different namespaces) would overwrite each other
+		if written[path] {
+			return fmt.Errorf("more than one document would be written to %s", path)
+		}
+		written[path] = true
+		if err := os.WriteFile(path, append([]byte(header), content...), 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("%sWrote %s/%s to %s\n", emoji("✨"), obj.GetKind(), obj.GetName(), path)
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// imageLine matches the image field of a container in YAML, the reference may be quoted
+var imageLine = regexp.MustCompile(`(?m)^(\s*(?:-\s+)?image:\s*)(["']?)([^"'\s#]+)(["']?)`)
+
+// manifestMediaTypes are the manifest types we accept, indexes first so multi-arch images are pinned to the index
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// registryClient is used for the registry queries, anonymous access is enough for public images
+var registryClient = &http.Client{
+	Timeout:   10 * time.Second,
+	Transport: userAgentTransport{next: http.DefaultTransport},
+}
+
+// imageRef is a parsed image reference like docker.io/library/nginx:1.25
+type imageRef struct {
+	// name is the reference without the tag as the model wrote it, e.g. nginx
+	name     string
+	registry string
+	repo     string
+	tag      string
+}
+
+// parseImageRef parses an image reference, images without registry are on Docker Hub and
+// images without tag use latest.
+func parseImageRef(image string) imageRef {
+	ref := imageRef{name: image, tag: "latest"}
+	//the tag is after the last colon of the last path component, a colon before is the registry port
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		ref.name, ref.tag = image[:i], image[i+1:]
+	}
+
+	first, rest, found := strings.Cut(ref.name, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.registry, ref.repo = first, rest
+	} else {
+		ref.registry, ref.repo = "docker.io", ref.name
+	}
+	if ref.registry == "docker.io" {
+		ref.registry = "registry-1.docker.io"
+		if !strings.Contains(ref.repo, "/") {
+			ref.repo = "library/" + ref.repo
+		}
+	}
+	return ref
+}
+
+// pinImages rewrites every image of the manifest which is referenced by tag to its digest, e.g.
+// nginx:1.25 becomes nginx@sha256:..., so the manifest always runs the same image. Images which are
+// already pinned stay as they are, images that can't be resolved are kept with a warning.
+// The manifest is rewritten line by line so the formatting of the model is kept.
+func pinImages(ctx context.Context, completion string) string {
+	digests := map[string]string{}
+	return imageLine.ReplaceAllStringFunc(completion, func(line string) string {
+		m := imageLine.FindStringSubmatch(line)
+		prefix, open, image, end := m[1], m[2], m[3], m[4]
+		if strings.Contains(image, "@") {
+			return line
+		}
+		digest, ok := digests[image]
+		if !ok {
+			var err error
+			digest, err = resolveDigest(ctx, parseImageRef(image))
+			if err != nil {
+				log.Warnf("unable to pin image %s: %v", image, err)
+			}
+			digests[image] = digest
+		}
+		if digest == "" {
+			return line
+		}
+		return prefix + open + parseImageRef(image).name + "@" + digest + end
+	})
+}
+
+// resolveDigest asks the registry for the digest of the tagged image. Registries which require a token,
+// like Docker Hub, tell us where to get one in the WWW-Authenticate header of the 401 response.
+func resolveDigest(ctx context.Context, ref imageRef) (string, error) {
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", ref.registry, ref.repo, ref.tag)
+	resp, err := headManifest(ctx, manifestURL, "")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err := registryToken(ctx, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return "", err
+		}
+		if resp, err = headManifest(ctx, manifestURL, token); err != nil {
+			return "", err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry returned %s", resp.Status)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", errors.New("registry returned no digest")
+	}
+	return digest, nil
+}
+
+// headManifest requests the headers of an image manifest, with the bearer token if it isn't empty.
+func headManifest(ctx context.Context, manifestURL, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := registryClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// bearerParam matches a parameter of a WWW-Authenticate header like realm="https://auth.docker.io/token"
+var bearerParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// registryToken gets an anonymous token from the realm of the WWW-Authenticate challenge.
+func registryToken(ctx context.Context, challenge string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return "", errors.New("registry requires authentication")
+	}
+	params := map[string]string{}
+	for _, m := range bearerParam.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid registry authentication challenge %q", challenge)
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := registryClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry token request returned %s", resp.Status)
+	}
+	//registries return the token as token, some older ones as access_token
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", errors.New("registry returned no token")
+}
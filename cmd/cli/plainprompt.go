@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// stdinReader reads the answers of the plain prompts, it's shared so piped answers buffered for one prompt
+// aren't lost for the next one, e.g. the context name after the y of the apply prompt.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// stdinConsumed is set when stdin was read to the end for the diagnostic input of the fix flag, the answers
+// are read from the terminal then, see answerReader
+var stdinConsumed bool
+
+// ttyReader reads the answers from the terminal once stdin is consumed, it's opened by the first answer
+var ttyReader *bufio.Reader
+
+// answerReader returns the reader of the plain prompts, stdin or, if stdin was consumed by the diagnostic input,
+// the terminal. Without a terminal there is nothing to read the answer from.
+func answerReader() (*bufio.Reader, error) {
+	if !stdinConsumed {
+		return stdinReader, nil
+	}
+	if ttyReader == nil {
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			return nil, fmt.Errorf("stdin was read for the diagnostic input and there is no terminal to confirm the apply, pass --require-confirmation=false to apply without confirmation: %w", err)
+		}
+		ttyReader = bufio.NewReader(tty)
+	}
+	return ttyReader, nil
+}
+
+// promptTerminal reports whether promptui can run, it needs a terminal on stdin and stdout.
+// Over SSH without a TTY or in scripts the plain prompts are used instead.
+func promptTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// readPlainAnswer prints the label to stderr, so it doesn't end up in piped output, and reads a line from stdin,
+// or the terminal if stdin was consumed. The end of the input without an answer is an empty answer.
+func readPlainAnswer(label string) (string, error) {
+	reader, err := answerReader()
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(os.Stderr, "%s: ", label)
+	line, err := reader.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("unable to read the answer from stdin: %w", err)
+	}
+	if errors.Is(err, io.EOF) {
+		//the answer didn't end the line, so the next output would follow the label
+		fmt.Fprintln(os.Stderr)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// plainActionPrompt is userActionPrompt without a terminal. y or yes applies, no answer, n or no doesn't apply
+// and any other answer is the prompt of a reprompt, like the added item of the select.
+func plainActionPrompt(label string) (string, error) {
+	answer, err := readPlainAnswer(label + " [y/N, or type a new prompt to reprompt]")
+	if err != nil {
+		return dontApply, err
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return apply, nil
+	case "", "n", "no":
+		return dontApply, nil
+	}
+	return answer, nil
+}
+
+// plainObjectActionPrompt is objectActionPrompt without a terminal. y or yes applies the object, s or skip
+// skips it and any other answer, no answer too, aborts the rest.
+func plainObjectActionPrompt(label string) (string, error) {
+	answer, err := readPlainAnswer(label + " [y/s/A, y applies, s skips, a aborts the rest]")
+	if err != nil {
+		return abortAll, err
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return apply, nil
+	case "s", "skip":
+		return skip, nil
+	}
+	return abortAll, nil
+}
+
+// plainConfirm is a confirmation like promptui's IsConfirm without a terminal, only y or yes confirm.
+func plainConfirm(label string) (bool, error) {
+	answer, err := readPlainAnswer(label + " [y/N]")
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// progress modes of the progress flag
+const (
+	progressInteractive = "interactive"
+	progressPlain       = "plain"
+)
+
+// plainProgress reports whether progress is reported as plain log lines instead of the spinner and banners.
+func plainProgress() bool {
+	return *progress == progressPlain
+}
+
+// progressf prints a timestamped progress line like "applied 2/3 deployment.apps/nginx created" in plain
+// progress mode, it does nothing otherwise. The lines go to stderr so they don't mix with raw output.
+func progressf(format string, a ...interface{}) {
+	if !plainProgress() {
+		return
+	}
+	line := fmt.Sprintf(format, a...)
+	//the progress lines are correlated with the log lines of the run
+	if traceID != "" {
+		line += " " + traceIDField + "=" + traceID
+	}
+	fmt.Fprintf(os.Stderr, "%s %s\n", time.Now().UTC().Format(time.RFC3339), line)
+}
+
+// countDocuments returns the number of objects in the manifest, for progress lines.
+func countDocuments(completion string) int {
+	objects, err := decodeManifest(completion)
+	if err != nil {
+		return 0
+	}
+	return len(objects)
+}
@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// addImagePullSecrets adds the secrets of the image-pull-secret flag to the imagePullSecrets of the pod spec of
+// every workload and of every ServiceAccount of the manifest. Pods which don't name a service account use the
+// default one of the namespace, which isn't part of the manifest, so the pod specs get the secrets themselves.
+// Secrets an object already references are not added again.
+func addImagePullSecrets(objects []*unstructured.Unstructured) error {
+	if len(*imagePullSecrets) == 0 {
+		return nil
+	}
+	for _, obj := range objects {
+		var path []string
+		if obj.GetKind() == "ServiceAccount" {
+			path = []string{"imagePullSecrets"}
+		} else if podSpec, ok := podSpecPaths[obj.GetKind()]; ok {
+			path = fieldPath(podSpec, "imagePullSecrets")
+		} else {
+			continue
+		}
+
+		secrets, _, err := unstructured.NestedSlice(obj.Object, path...)
+		if err != nil {
+			return fmt.Errorf("unable to read the imagePullSecrets of %s/%s: %w", obj.GetKind(), obj.GetName(), err)
+		}
+		existing := map[string]bool{}
+		for _, s := range secrets {
+			if ref, ok := s.(map[string]interface{}); ok {
+				if name, ok := ref["name"].(string); ok {
+					existing[name] = true
+				}
+			}
+		}
+		added := false
+		for _, name := range *imagePullSecrets {
+			if existing[name] {
+				continue
+			}
+			secrets = append(secrets, map[string]interface{}{"name": name})
+			existing[name] = true
+			added = true
+		}
+		if !added {
+			continue
+		}
+		if err := unstructured.SetNestedSlice(obj.Object, secrets, path...); err != nil {
+			return err
+		}
+		log.Debugf("added the image pull secrets %v to %s/%s", *imagePullSecrets, obj.GetKind(), obj.GetName())
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+// requiredVerbs returns the verbs needed for every object, server-side apply needs patch
+// for existing objects and create for new ones, delete mode only needs delete.
+func requiredVerbs() []string {
+	if *deleteMode {
+		return []string{"delete"}
+	}
+	return []string{"create", "patch"}
+}
+
+// checkApplyPermissions checks with a SelfSubjectAccessReview for every object whether the current
+// user is allowed to apply it. All missing permissions are reported together in the returned error.
+func checkApplyPermissions(ctx context.Context, c kubernetes.Interface, objects []*unstructured.Unstructured, namespace string) error {
+	var missing []string
+	for _, obj := range objects {
+		mapping, err := restMapping(c, obj.GroupVersionKind())
+		if err != nil {
+			//this happens for custom resources whose CRD is part of the same manifest,
+			//we can't check them before the CRD is applied
+			log.Debugf("unable to check permissions for %s/%s: %v", obj.GetKind(), obj.GetName(), err)
+			continue
+		}
+
+		//cluster scoped objects are checked without a namespace
+		var ns string
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			ns = obj.GetNamespace()
+			if ns == "" {
+				ns = namespace
+			}
+		}
+
+		for _, verb := range requiredVerbs() {
+			review := &authorizationv1.SelfSubjectAccessReview{
+				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationv1.ResourceAttributes{
+						Namespace: ns,
+						Verb:      verb,
+						Group:     mapping.Resource.Group,
+						Resource:  mapping.Resource.Resource,
+						Name:      obj.GetName(),
+					},
+				},
+			}
+			result, err := c.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+			if err != nil {
+				return err
+			}
+			if !result.Status.Allowed {
+				missing = append(missing, describePermission(verb, mapping.Resource.Resource, mapping.Resource.Group, obj.GetName(), ns))
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing permissions to apply the manifest:\n  - %s", strings.Join(missing, "\n  - "))
+	}
+	return nil
+}
+
+// describePermission formats a permission like kubectl auth can-i, e.g. "patch deployments.apps/nginx in namespace default".
+func describePermission(verb, resource, group, name, namespace string) string {
+	if group != "" {
+		resource = resource + "." + group
+	}
+	desc := fmt.Sprintf("%s %s/%s", verb, resource, name)
+	if namespace != "" {
+		desc += " in namespace " + namespace
+	}
+	return desc
+}
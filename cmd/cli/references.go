@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+// objectReference is an object a pod spec refers to by name, e.g. the Secret of a volume
+type objectReference struct {
+	kind string
+	name string
+}
+
+// podSpecReferences returns the Secrets, ConfigMaps, ServiceAccounts and PersistentVolumeClaims a pod spec
+// refers to. References marked as optional are left out, the pod starts without them.
+func podSpecReferences(podSpec map[string]interface{}) []objectReference {
+	var refs []objectReference
+	add := func(kind string, m map[string]interface{}, nameField string) {
+		name, _, _ := unstructured.NestedString(m, nameField)
+		optional, _, _ := unstructured.NestedBool(m, "optional")
+		if name != "" && !optional {
+			refs = append(refs, objectReference{kind: kind, name: name})
+		}
+	}
+
+	//every namespace has the default service account
+	if sa, _, _ := unstructured.NestedString(podSpec, "serviceAccountName"); sa != "" && sa != "default" {
+		refs = append(refs, objectReference{kind: "ServiceAccount", name: sa})
+	}
+	for _, s := range nestedMaps(podSpec, "imagePullSecrets") {
+		add("Secret", s, "name")
+	}
+	for _, v := range nestedMaps(podSpec, "volumes") {
+		if m, ok := v["secret"].(map[string]interface{}); ok {
+			add("Secret", m, "secretName")
+		}
+		if m, ok := v["configMap"].(map[string]interface{}); ok {
+			add("ConfigMap", m, "name")
+		}
+		if m, ok := v["persistentVolumeClaim"].(map[string]interface{}); ok {
+			add("PersistentVolumeClaim", m, "claimName")
+		}
+		projected, _ := v["projected"].(map[string]interface{})
+		for _, source := range nestedMaps(projected, "sources") {
+			if m, ok := source["secret"].(map[string]interface{}); ok {
+				add("Secret", m, "name")
+			}
+			if m, ok := source["configMap"].(map[string]interface{}); ok {
+				add("ConfigMap", m, "name")
+			}
+		}
+	}
+	for _, field := range []string{"initContainers", "containers"} {
+		for _, c := range nestedMaps(podSpec, field) {
+			for _, env := range nestedMaps(c, "env") {
+				if m, found, _ := unstructured.NestedMap(env, "valueFrom", "secretKeyRef"); found {
+					add("Secret", m, "name")
+				}
+				if m, found, _ := unstructured.NestedMap(env, "valueFrom", "configMapKeyRef"); found {
+					add("ConfigMap", m, "name")
+				}
+			}
+			for _, envFrom := range nestedMaps(c, "envFrom") {
+				if m, ok := envFrom["secretRef"].(map[string]interface{}); ok {
+					add("Secret", m, "name")
+				}
+				if m, ok := envFrom["configMapRef"].(map[string]interface{}); ok {
+					add("ConfigMap", m, "name")
+				}
+			}
+		}
+	}
+	return refs
+}
+
+// nestedMaps returns the maps of the list at the field, other entries are skipped.
+func nestedMaps(obj map[string]interface{}, field string) []map[string]interface{} {
+	list, _ := obj[field].([]interface{})
+	var maps []map[string]interface{}
+	for _, item := range list {
+		if m, ok := item.(map[string]interface{}); ok {
+			maps = append(maps, m)
+		}
+	}
+	return maps
+}
+
+// referenceExists reports whether the referenced object exists in the namespace.
+func referenceExists(ctx context.Context, c kubernetes.Interface, namespace string, ref objectReference) (bool, error) {
+	var err error
+	switch ref.kind {
+	case "Secret":
+		_, err = c.CoreV1().Secrets(namespace).Get(ctx, ref.name, metav1.GetOptions{})
+	case "ConfigMap":
+		_, err = c.CoreV1().ConfigMaps(namespace).Get(ctx, ref.name, metav1.GetOptions{})
+	case "ServiceAccount":
+		_, err = c.CoreV1().ServiceAccounts(namespace).Get(ctx, ref.name, metav1.GetOptions{})
+	case "PersistentVolumeClaim":
+		_, err = c.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, ref.name, metav1.GetOptions{})
+	}
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// danglingReferences returns the references of the workloads in the manifest to Secrets, ConfigMaps,
+// ServiceAccounts and PersistentVolumeClaims which neither the manifest nor the target namespace contain,
+// like "Deployment/web: Secret db-credentials doesn't exist in namespace default".
+func danglingReferences(ctx context.Context, completion string) ([]string, error) {
+	//the references have to match the objects which are applied, annotations don't reference anything
+	objects, namespace, err := prepareObjects(ctx, completion, nil)
+	if err != nil {
+		return nil, err
+	}
+	c, err := getClientset()
+	if err != nil {
+		return nil, err
+	}
+	namespaceOf := func(obj *unstructured.Unstructured) string {
+		if obj.GetNamespace() != "" {
+			return obj.GetNamespace()
+		}
+		return namespace
+	}
+
+	//objects of the manifest are created by the apply, they don't have to exist yet
+	defined := map[string]bool{}
+	for _, obj := range objects {
+		defined[fmt.Sprintf("%s/%s/%s", obj.GetKind(), namespaceOf(obj), obj.GetName())] = true
+	}
+
+	var problems []string
+	checked := map[string]bool{}
+	for _, obj := range objects {
+		path, ok := podSpecPaths[obj.GetKind()]
+		if !ok {
+			continue
+		}
+		podSpec, found, _ := unstructured.NestedMap(obj.Object, path...)
+		if !found {
+			continue
+		}
+		ns := namespaceOf(obj)
+		for _, ref := range podSpecReferences(podSpec) {
+			key := fmt.Sprintf("%s/%s/%s", ref.kind, ns, ref.name)
+			if defined[key] {
+				continue
+			}
+			exists, ok := checked[key]
+			if !ok {
+				if exists, err = referenceExists(ctx, c, ns, ref); err != nil {
+					//e.g. we may not be allowed to read secrets, that doesn't mean it's missing
+					log.Debugf("unable to check %s %s in namespace %s: %v", ref.kind, ref.name, ns, err)
+					exists = true
+				}
+				checked[key] = exists
+			}
+			if !exists {
+				problems = append(problems, fmt.Sprintf("%s/%s: %s %s doesn't exist in namespace %s", obj.GetKind(), obj.GetName(), ref.kind, ref.name, ns))
+			}
+		}
+	}
+	return problems, nil
+}
+
+// warnDanglingReferences prints the dangling references of the manifest, like the lint it's advisory,
+// the referenced objects may well be created after the apply.
+func warnDanglingReferences(ctx context.Context, completion string) {
+	problems, err := danglingReferences(ctx, completion)
+	if err != nil {
+		log.Debugf("unable to check references: %v", err)
+		return
+	}
+	for _, problem := range problems {
+		log.Warnf("reference: %s", problem)
+	}
+}
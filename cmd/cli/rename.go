@@ -0,0 +1,146 @@
+package cli
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// labelPaths are the label maps which are rewritten together with the names, so selectors
+// and the labels they select stay in sync
+var labelPaths = [][]string{
+	{"metadata", "labels"},
+	{"spec", "selector"},
+	{"spec", "selector", "matchLabels"},
+	{"spec", "template", "metadata", "labels"},
+	{"spec", "jobTemplate", "spec", "template", "metadata", "labels"},
+}
+
+// selectorPaths are the label maps of labelPaths which select other objects
+var selectorPaths = [][]string{
+	{"spec", "selector"},
+	{"spec", "selector", "matchLabels"},
+}
+
+// nameReferenceFields are the string fields which hold the name of another object, e.g. the claimName of a volume
+var nameReferenceFields = map[string]bool{
+	"claimName":          true,
+	"serviceName":        true,
+	"secretName":         true,
+	"serviceAccountName": true,
+	"volumeName":         true,
+	"priorityClassName":  true,
+	"storageClassName":   true,
+	"ingressClassName":   true,
+	"runtimeClassName":   true,
+}
+
+// nameReferenceParents are the fields whose name holds the name of another object, e.g. configMapRef.name
+// or the items of imagePullSecrets
+var nameReferenceParents = map[string]bool{
+	"configMapRef":     true,
+	"secretRef":        true,
+	"configMapKeyRef":  true,
+	"secretKeyRef":     true,
+	"configMap":        true,
+	"imagePullSecrets": true,
+	"scaleTargetRef":   true,
+	"targetRef":        true,
+	"roleRef":          true,
+	"subjects":         true,
+	"service":          true,
+}
+
+// renameObjects adds the name-prefix and name-suffix to the name of every object.
+// Namespaces referenced by other objects and the labels selected by a selector of the manifest whose value
+// is one of the old names (e.g. app: nginx for a Deployment named nginx selecting app: nginx) are renamed too,
+// so selectors and the labels they select stay in sync. Other labels are kept. Known reference fields like
+// configMapRef.name or claimName still referencing an old name are reported with a warning, as they won't
+// match the renamed object.
+func renameObjects(objects []*unstructured.Unstructured) {
+	if *namePrefix == "" && *nameSuffix == "" {
+		return
+	}
+
+	renamed := map[string]string{}
+	namespaces := map[string]string{}
+	for _, obj := range objects {
+		newName := *namePrefix + obj.GetName() + *nameSuffix
+		renamed[obj.GetName()] = newName
+		if obj.GetKind() == "Namespace" {
+			namespaces[obj.GetName()] = newName
+		}
+		obj.SetName(newName)
+	}
+
+	//the labels a selector of the manifest selects by one of the old names, e.g. app=nginx
+	selected := map[string]bool{}
+	for _, obj := range objects {
+		for _, path := range selectorPaths {
+			labels, found, err := unstructured.NestedStringMap(obj.Object, path...)
+			if !found || err != nil {
+				continue
+			}
+			for k, v := range labels {
+				if _, ok := renamed[v]; ok {
+					selected[k+"="+v] = true
+				}
+			}
+		}
+	}
+
+	for _, obj := range objects {
+		//objects in a namespace of the manifest move with the renamed namespace
+		if ns, ok := namespaces[obj.GetNamespace()]; ok {
+			obj.SetNamespace(ns)
+		}
+
+		for _, path := range labelPaths {
+			labels, found, err := unstructured.NestedStringMap(obj.Object, path...)
+			if !found || err != nil {
+				continue
+			}
+			for k, v := range labels {
+				if newName, ok := renamed[v]; ok && selected[k+"="+v] {
+					labels[k] = newName
+				}
+			}
+			if err := unstructured.SetNestedStringMap(obj.Object, labels, path...); err != nil {
+				log.Warnf("unable to rename labels %s of %s/%s: %v", strings.Join(path, "."), obj.GetKind(), obj.GetName(), err)
+			}
+		}
+
+		for _, ref := range findNameReferences(obj.Object, renamed, "", "") {
+			log.Warnf("%s/%s: %s still references the old name, it won't match the renamed object", obj.GetKind(), obj.GetName(), ref)
+		}
+	}
+}
+
+// findNameReferences returns the paths of the nameReferenceFields and the names of the nameReferenceParents
+// whose value is one of the old names. Names of the object's own parts, like the name of a container, a port
+// or a volume mount, aren't references. parent is the field v is the value of, or an item of.
+func findNameReferences(v interface{}, renamed map[string]string, path, parent string) []string {
+	var refs []string
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			//metadata.name is the name we just renamed ourselves
+			if path == "" && k == "metadata" {
+				continue
+			}
+			if s, ok := child.(string); ok {
+				if _, ok := renamed[s]; ok && (nameReferenceFields[k] || (k == "name" && nameReferenceParents[parent])) {
+					refs = append(refs, strings.TrimPrefix(path+"."+k, "."))
+				}
+				continue
+			}
+			refs = append(refs, findNameReferences(child, renamed, path+"."+k, k)...)
+		}
+	case []interface{}:
+		for _, child := range val {
+			refs = append(refs, findNameReferences(child, renamed, path+"[]", parent)...)
+		}
+	}
+	return refs
+}
@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+	log "github.com/sirupsen/logrus"
+)
+
+// responsesRequest is the request body of the OpenAI Responses API, see
+// https://platform.openai.com/docs/api-reference/responses/create
+type responsesRequest struct {
+	Model       string   `json:"model"`
+	Input       string   `json:"input"`
+	Temperature *float32 `json:"temperature,omitempty"`
+}
+
+// responsesResponse is the part of the Responses API response we use
+type responsesResponse struct {
+	Model  string `json:"model"`
+	Output []struct {
+		Type    string `json:"type"`
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	} `json:"output"`
+	Usage struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error *openai.APIError `json:"error,omitempty"`
+}
+
+// openaiResponsesCompletion sends the prompt to the OpenAI Responses API, which supersedes chat completions
+// for some models. go-openai doesn't support the Responses API yet, so this is a plain HTTP request against
+// the same base URL and API key as the other clients. The result is the generated text, just like the
+// other completion functions return it. deploymentName is the resolved model, e.g. of the model flag.
+func (c *oaiClients) openaiResponsesCompletion(ctx context.Context, prompt *strings.Builder, temp float32, deploymentName string) (string, completionInfo, error) {
+	request := responsesRequest{
+		Model: deploymentName,
+		Input: prompt.String(),
+	}
+	//reasoning models reject the temperature parameter, even a zero one
+	if acceptsTemperature(deploymentName) {
+		request.Temperature = &temp
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", completionInfo{}, err
+	}
+	log.Debugf("prompt: %s", prompt.String())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.baseURL, "/")+"/responses", bytes.NewReader(body))
+	if err != nil {
+		return "", completionInfo{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+*openAIAPIKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", completionInfo{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", completionInfo{}, err
+	}
+
+	var result responsesResponse
+	//errors are returned like the go-openai client does, so rate limited requests are retried in gptCompletion
+	if resp.StatusCode != http.StatusOK {
+		if err := json.Unmarshal(data, &result); err == nil && result.Error != nil {
+			return "", completionInfo{}, &openai.RequestError{HTTPStatusCode: resp.StatusCode, Err: result.Error}
+		}
+		return "", completionInfo{}, &openai.RequestError{HTTPStatusCode: resp.StatusCode, Err: fmt.Errorf("%s", strings.TrimSpace(string(data)))}
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", completionInfo{}, fmt.Errorf("unable to parse responses API response: %w", err)
+	}
+
+	//the output can contain other items like reasoning, the generated text is in the output_text of the messages
+	var text strings.Builder
+	for _, item := range result.Output {
+		if item.Type != "message" {
+			continue
+		}
+		for _, content := range item.Content {
+			if content.Type == "output_text" {
+				text.WriteString(content.Text)
+			}
+		}
+	}
+	if text.Len() == 0 {
+		return "", completionInfo{}, errors.New("the responses API returned no text")
+	}
+	log.Debugf("result: %s", text.String())
+
+	return trimTicks(text.String()), completionInfo{Model: result.Model, PromptTokens: result.Usage.InputTokens, CompletionTokens: result.Usage.OutputTokens}, nil
+}
@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// applyState records which objects of a manifest were already applied, so an interrupted
+// apply can be continued with the resume flag instead of generating the manifest again
+type applyState struct {
+	ManifestSHA256 string            `json:"manifestSHA256"`
+	Manifest       string            `json:"manifest"`
+	Annotations    map[string]string `json:"annotations,omitempty"`
+	Delete         bool              `json:"delete,omitempty"`
+	Applied        []string          `json:"applied"`
+}
+
+// manifestSHA256 returns the hex encoded SHA-256 of the manifest, which identifies it in the audit log and the apply state.
+func manifestSHA256(completion string) string {
+	sum := sha256.Sum256([]byte(completion))
+	return hex.EncodeToString(sum[:])
+}
+
+// stateFile returns the path of a state file in the user's config directory.
+func stateFile(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "kubectl-assistant", name), nil
+}
+
+// applyStateFile returns the path of the apply state file in the user's config directory.
+func applyStateFile() (string, error) {
+	return stateFile("apply-state.json")
+}
+
+// loadApplyState returns the state of the last interrupted apply, or nil if there is none.
+func loadApplyState() (*applyState, error) {
+	path, err := applyStateFile()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state applyState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("unable to parse apply state %s: %w", path, err)
+	}
+	return &state, nil
+}
+
+// save writes the state, it's called after every applied object so a crash loses at most one object.
+func (s *applyState) save() error {
+	path, err := applyStateFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	//the manifest may contain secrets, so only the user can read it
+	return os.WriteFile(path, data, 0o600)
+}
+
+// isApplied reports whether the object was already applied when the manifest was applied before.
+func (s *applyState) isApplied(key string) bool {
+	for _, applied := range s.Applied {
+		if applied == key {
+			return true
+		}
+	}
+	return false
+}
+
+// clearApplyState removes the state once every object of the manifest was applied.
+func clearApplyState() error {
+	path, err := applyStateFile()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// resumeApply continues the interrupted apply of the last manifest, the objects which were
+// already applied are skipped and the manifest isn't generated again.
+func resumeApply(ctx context.Context) error {
+	state, err := loadApplyState()
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return errors.New("there is no interrupted apply to resume")
+	}
+	if state.Delete != *deleteMode {
+		return errors.New("the interrupted run used a different --delete mode, run it with the same flags to resume")
+	}
+
+	fmt.Printf("%sResuming the apply of the following manifest, %d objects were already applied:\n%s\n", emoji("✨"), len(state.Applied), state.Manifest)
+	action, err := newApprovalProvider().approve(ctx, state.Manifest)
+	if err != nil {
+		return err
+	}
+	//there's no model involved when resuming, so there's nothing to reprompt
+	if action != apply {
+		return nil
+	}
+	return applyManifest(ctx, state.Manifest, state.Annotations)
+}
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+// reviewInstructions asks the model for a security review instead of a manifest
+const reviewInstructions = "You are a Kubernetes security expert. Review the security posture of the following Kubernetes manifest and reply in plain text, don't generate YAML. List every finding on its own line starting with its severity (HIGH, MEDIUM or LOW) and the object, e.g. HIGH Deployment/api: container api runs privileged, followed by a short suggestion how to fix it. Look for privileged containers, allowPrivilegeEscalation, running as root, added capabilities, hostPath mounts, hostNetwork, hostPID and hostIPC, missing resource requests and limits, mutable image tags like latest, secrets in environment variables, automounted service account tokens and overly broad RBAC rules. If there are no findings say so. "
+
+// reviewCmd returns the review subcommand, which explains the security posture of a manifest file.
+func reviewCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "review FILE",
+		Short: "Review the security posture of a manifest",
+		Long:  "Review the security posture of a manifest file, e.g. review ./manifest.yaml, or - for stdin. The model lists findings like privileged containers, hostPath mounts or missing limits. Nothing is generated or applied.",
+		Args:  cobra.ExactArgs(1),
+		PreRunE: func(_ *cobra.Command, _ []string) error {
+			return requireAPIKey()
+		},
+		RunE: func(_ *cobra.Command, args []string) error {
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer cancel()
+			resolveDeploymentName()
+			return reviewManifest(ctx, args[0])
+		},
+	}
+}
+
+// reviewManifest sends the manifest of the file to the model for a security review and prints the findings.
+// The values of Secrets are redacted before the manifest leaves the machine.
+func reviewManifest(ctx context.Context, path string) error {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read manifest: %w", err)
+	}
+	if err := checkManifestSize(string(data)); err != nil {
+		return err
+	}
+	objects, err := decodeManifest(string(data))
+	if err != nil {
+		return fmt.Errorf("%s is not a valid manifest: %w", path, err)
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("%s has no objects to review", path)
+	}
+
+	client, err := newOAIClients()
+	if err != nil {
+		return err
+	}
+	var prompt strings.Builder
+	prompt.WriteString(reviewInstructions)
+	for _, obj := range objects {
+		redactSecret(obj)
+		doc, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&prompt, "\n---\n%s", doc)
+	}
+	findings, _, err := completeWithRetry(ctx, client, &prompt, float32(*temperature), *openAIDeploymentName, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Println(strings.TrimSpace(findings))
+	return nil
+}
@@ -2,10 +2,14 @@ package cli
 //COMPLETE
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/janeczku/go-spinner"
 	"github.com/manifoldco/promptui"
@@ -13,6 +17,10 @@ import (
 	"github.com/spf13/cobra"
 	flag "github.com/spf13/pflag"
 	"github.com/walles/env"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/exp/slices"
+	"golang.org/x/term"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
@@ -20,6 +28,15 @@ const (
 	apply     = "Apply"
 	dontApply = "Don't Apply"
 	reprompt  = "Reprompt"
+
+	// actions for a single object with the confirm-each flag, apply is shared with the manifest prompt
+	skip     = "Skip"
+	abortAll = "Abort the rest"
+
+	// clarifyingQuestionPrefix marks a model response as a question instead of a manifest
+	clarifyingQuestionPrefix = "QUESTION:"
+	// maxClarifyingQuestions is how many questions the model may ask before it has to generate
+	maxClarifyingQuestions = 2
 )
 
 //these variables help us work with the various environment variables
@@ -34,26 +51,207 @@ var (
 	openAIEndpoint       = flag.String("openai-endpoint", env.GetOr("OPENAI_ENDPOINT", env.String, openaiAPIURLv1), "The endpoint for OpenAI service. Defaults to"+openaiAPIURLv1+". Set this to your Local AI endpoint or Azure OpenAI Service, if needed.")                                      // The endpoint for the OpenAI service.
 	azureModelMap        = flag.StringToString("azure-openai-map", env.GetOr("AZURE_OPENAI_MAP", env.Map(env.String, "=", env.String, ""), map[string]string{}), "The mapping from OpenAI model to Azure OpenAI deployment. Defaults to empty map. Example format: gpt-3.5-turbo=my-deployment.")  // The mapping from OpenAI model to Azure OpenAI deployment.
 	requireConfirmation  = flag.Bool("require-confirmation", env.GetOr("REQUIRE_CONFIRMATION", strconv.ParseBool, true), "Whether to require confirmation before executing the command. Defaults to true.")                                                                                        // Whether to require confirmation before executing the command.
-	temperature          = flag.Float64("temperature", env.GetOr("TEMPERATURE", env.WithBitSize(strconv.ParseFloat, 64), 0.0), "The temperature to use for the model. Range is between 0 and 1. Set closer to 0 if your want output to be more deterministic but less creative. Defaults to 0.0.") // The temperature to use for the model.
+	temperature          = flag.Float64("temperature", env.GetOr("TEMPERATURE", env.WithBitSize(strconv.ParseFloat, 64), 0.0), "The temperature to use for the model. Range is between 0 and 2 for OpenAI and Azure OpenAI, and between 0 and 1 for other endpoints. Set closer to 0 if your want output to be more deterministic but less creative. Defaults to 0.0.") // The temperature to use for the model.
 	raw                  = flag.Bool("raw", false, "Prints the raw YAML output immediately. Defaults to false.")                                                                                                                                                                                   // Whether to print the raw YAML output immediately.
 	usek8sAPI            = flag.Bool("use-k8s-api", env.GetOr("USE_K8S_API", strconv.ParseBool, false), "Whether to use the Kubernetes API to create resources with function calling. Defaults to false.")                                                                                         // Whether to use the Kubernetes API to create resources with function calling.
-	k8sOpenAPIURL        = flag.String("k8s-openapi-url", env.GetOr("K8S_OPENAPI_URL", env.String, ""), "The URL to a Kubernetes OpenAPI spec. Only used if use-k8s-api flag is true.")                                                                                                            // The URL to a Kubernetes OpenAPI spec.
+	k8sOpenAPIURL        = flag.String("k8s-openapi-url", env.GetOr("K8S_OPENAPI_URL", env.String, ""), "The URL to a Kubernetes OpenAPI spec, or the path of a file with the spec, e.g. ./openapi.json or file:///specs/openapi.json. Only used if use-k8s-api flag is true.")                                                                                                            // The URL to a Kubernetes OpenAPI spec.
+	targetedSchema       = flag.Bool("targeted-schema", env.GetOr("TARGETED_SCHEMA", strconv.ParseBool, false), "Whether to fetch only the OpenAPI v3 document of the API group the model asks about instead of the full /openapi/v2 schema. Only used if use-k8s-api flag is true and k8s-openapi-url is not set. Defaults to false.") // Whether to fetch per-group OpenAPI v3 schemas.
+	clarify              = flag.Bool("clarify", env.GetOr("CLARIFY", strconv.ParseBool, false), "Whether to let the model ask up to two clarifying questions before generating the manifest. Defaults to false.") // Whether the model may ask clarifying questions.
+	noSpinner            = flag.Bool("no-spinner", env.GetOr("NO_SPINNER", strconv.ParseBool, false), "Whether to disable the processing spinner. The spinner is always disabled when stdout or stderr is not a terminal. Defaults to false.") // Whether to disable the processing spinner.
+	explainRBAC          = flag.Bool("explain-rbac", env.GetOr("EXPLAIN_RBAC", strconv.ParseBool, false), "Whether to check that the current user has permission to apply every object before applying anything, and report missing permissions. Defaults to false.") // Whether to check RBAC permissions before applying.
+	promptFile           = flag.String("prompt-file", env.GetOr("PROMPT_FILE", env.String, ""), "The path to a file whose content is used as the prompt, before any prompt given as arguments.") // The path to a file containing the prompt.
+	watch                = flag.Bool("watch", false, "Whether to watch the prompt file and regenerate and apply the manifest every time it changes. Requires prompt-file. Defaults to false.") // Whether to watch the prompt file for changes.
+	outputFile           = flag.String("output-file", env.GetOr("OUTPUT_FILE", env.String, ""), "The path to write the generated manifest to instead of applying it. With split this is a directory.") // The path to write the generated manifest to.
+	split                = flag.Bool("split", false, "Whether to write every document of the generated manifest to its own <kind>-<name>.yaml file in the output-file directory. Defaults to false.") // Whether to split the manifest into one file per document.
+	cloud                = flag.String("cloud", env.GetOr("CLOUD", env.String, ""), "The cloud provider (aws, gcp or azure) to generate provider specific annotations for. Set to auto to detect it from the cluster's nodes.") // The cloud provider to generate annotations for.
+	skipUnchanged        = flag.Bool("skip-unchanged", env.GetOr("SKIP_UNCHANGED", strconv.ParseBool, false), "Whether to skip applying objects which a server-side dry-run of the apply wouldn't change, so their resourceVersion isn't bumped. Defaults to false.") // Whether to skip applying unchanged objects.
+	historyFile          = flag.String("prompt-history-file", env.GetOr("PROMPT_HISTORY_FILE", env.String, defaultHistoryFile()), "The path to the file every prompt is recorded to. Set to an empty string to disable the history.") // The path to the prompt history file.
+	fromHistory          = flag.Bool("from-history", false, "Whether to select the prompt interactively from the prompt history. Defaults to false.") // Whether to select the prompt from history.
+	noHistory            = flag.Bool("no-history", false, "Whether to not record this prompt in the prompt history, e.g. for sensitive prompts. Defaults to false.") // Whether to skip recording the prompt.
+	deleteMode           = flag.Bool("delete", false, "Whether to delete the objects of the generated manifest from the cluster instead of applying them. Defaults to false.") // Whether to delete the objects instead of applying them.
+	deletePropagation    = flag.String("delete-propagation", env.GetOr("DELETE_PROPAGATION", env.String, ""), "The propagation policy used with delete, one of Foreground, Background or Orphan. Defaults to the server's default for the resource.") // The propagation policy used with delete.
+	namePrefix           = flag.String("name-prefix", env.GetOr("NAME_PREFIX", env.String, ""), "The prefix added to the name of every generated object before applying it.") // The prefix added to every object name.
+	nameSuffix           = flag.String("name-suffix", env.GetOr("NAME_SUFFIX", env.String, ""), "The suffix added to the name of every generated object before applying it.") // The suffix added to every object name.
+	retryMaxDuration     = flag.Duration("retry-max-duration", env.GetOr("RETRY_MAX_DURATION", time.ParseDuration, 0), "The maximum total time spent retrying rate limited requests, e.g. 30s. Defaults to 0 which only limits the number of retries.") // The maximum total time spent retrying.
+	production           = flag.Bool("production", env.GetOr("PRODUCTION", strconv.ParseBool, false), "Whether to also generate a PodDisruptionBudget and a HorizontalPodAutoscaler for every generated Deployment. Defaults to false.") // Whether to generate production companion resources.
+	output               = flag.String("output", env.GetOr("OUTPUT", env.String, ""), "The output format. Set to json to print the generated manifest and the model which generated it as JSON instead of applying it.") // The output format.
+	auditLog             = flag.String("audit-log", env.GetOr("AUDIT_LOG", env.String, ""), "The path to a file every generated manifest is recorded to, with the prompt, the model, its system fingerprint and whether it was applied.") // The path to the audit log.
+	only                 = flag.StringSlice("only", []string{}, "Only apply the documents matching one of these kind/name selectors, e.g. configmap/nginx-config. Use kind/* to match every name of a kind.") // The kind/name selectors of the documents to apply.
+	kubeServer           = flag.String("kube-server", env.GetOr("KUBE_SERVER", env.String, ""), "The address of the Kubernetes API server to apply to instead of using the kubeconfig file.") // The Kubernetes API server to use instead of the kubeconfig.
+	kubeToken            = flag.String("kube-token", env.GetOr("KUBE_TOKEN", env.String, ""), "The bearer token used to authenticate to the kube-server.") // The bearer token for the kube-server.
+	kubeInsecure         = flag.Bool("kube-insecure", env.GetOr("KUBE_INSECURE", strconv.ParseBool, false), "Whether to skip verifying the certificate of the kube-server. Defaults to false.") // Whether to skip TLS verification of the kube-server.
+	schemaFix            = flag.Bool("schema-fix", env.GetOr("SCHEMA_FIX", strconv.ParseBool, false), "Whether to validate the generated manifest against the Kubernetes OpenAPI schema and let the model correct unknown or invalid fields once. Requires use-k8s-api. Defaults to false.") // Whether to let the model fix schema validation errors.
+	completionAPI        = flag.String("completion-api", env.GetOr("COMPLETION_API", env.String, "auto"), "The OpenAI API used for generation, one of chat, completions, responses or auto. Auto uses the completions API for known legacy models only. Defaults to auto.") // The OpenAI API used for generation.
+	contextNamespaceDefault = flag.String("context-namespace-default", env.GetOr("CONTEXT_NAMESPACE_DEFAULT", env.String, ""), "The namespace used for objects when neither the manifest nor the namespace flag specify one, instead of the current context's namespace.") // The fallback namespace instead of the context's namespace.
+	annotate             = flag.Bool("annotate", env.GetOr("ANNOTATE", strconv.ParseBool, false), "Whether to annotate applied objects with the prompt and the model which generated them. Defaults to false.") // Whether to annotate applied objects with the prompt and model.
+	contextWindow        = flag.Int("context-window", env.GetOr("CONTEXT_WINDOW", strconv.Atoi, 0), "The context window of the model in tokens. Prompts which don't fit are generated in multiple parts. Defaults to the context window of known OpenAI models.") // The context window of the model in tokens.
+	confirmEach          = flag.Bool("confirm-each", env.GetOr("CONFIRM_EACH", strconv.ParseBool, false), "Whether to ask for confirmation before applying each object of the manifest, with options to apply, skip or abort the rest. Defaults to false.") // Whether to confirm each object separately.
+	azureModelMapFile    = flag.String("azure-openai-map-file", env.GetOr("AZURE_OPENAI_MAP_FILE", env.String, ""), "A YAML or JSON file with the mapping from OpenAI model to Azure OpenAI deployment. Mappings of --azure-openai-map take precedence.") // A file with the mapping from OpenAI model to Azure OpenAI deployment.
+	strictYAML           = flag.Bool("strict-yaml", env.GetOr("STRICT_YAML", strconv.ParseBool, false), "Whether to fail on any document of the manifest that can't be decoded instead of stopping at it. Defaults to false.") // Whether to fail on documents that can't be decoded.
+	fromFile             = flag.StringSlice("from-file", []string{}, "Local files to embed into generated ConfigMaps and Secrets, as path or key=path like kubectl create configmap --from-file. The content is added after generation and never passes through the model.") // Local files to embed into ConfigMaps and Secrets.
+	resume               = flag.Bool("resume", env.GetOr("RESUME", strconv.ParseBool, false), "Whether to resume the interrupted apply of the last manifest, skipping the objects which were already applied, instead of generating a new manifest. Defaults to false.") // Whether to resume the last interrupted apply.
+	lint                 = flag.Bool("lint", env.GetOr("LINT", strconv.ParseBool, false), "Whether to warn about workloads without liveness or readiness probes, resource limits or a non-root security context before applying. Defaults to false.") // Whether to warn about missing best practices before applying.
+	ascii                = flag.Bool("ascii", env.GetOr("ASCII", strconv.ParseBool, false), "Whether to only use ASCII characters for the spinner, the prompts and messages, for terminals without Unicode support. Defaults to false.") // Whether to only use ASCII characters.
+	spinnerFrames        = flag.StringSlice("spinner-charset", env.GetOr("SPINNER_CHARSET", env.ListOf(env.String, ","), []string{}), "The frames of the processing spinner, comma separated, e.g. .,o,O. Defaults to braille frames, or ASCII frames with --ascii.") // The frames of the processing spinner.
+	testNamespace        = flag.Bool("test-namespace", env.GetOr("TEST_NAMESPACE", strconv.ParseBool, false), "Whether to apply the manifest to a temporary namespace first, report the result and delete the namespace before offering to apply to the real namespace. Defaults to false.") // Whether to test the manifest in a temporary namespace first.
+	yamlIndent           = flag.Int("yaml-indent", env.GetOr("YAML_INDENT", strconv.Atoi, 0), "The indentation the generated manifest is re-serialized with, e.g. 2. Defaults to 0 which keeps the model's formatting.") // The indentation of the re-serialized manifest.
+	yamlKeyOrder         = flag.String("yaml-key-order", env.GetOr("YAML_KEY_ORDER", env.String, ""), "The key order the generated manifest is re-serialized with, kubernetes (apiVersion, kind, metadata first) or alphabetical. Defaults to the model's order.") // The key order of the re-serialized manifest.
+	trackLastApplied     = flag.Bool("track-last-applied", env.GetOr("TRACK_LAST_APPLIED", strconv.ParseBool, false), "Whether to write the kubectl.kubernetes.io/last-applied-configuration annotation on applied objects like client-side kubectl apply does. Defaults to false.") // Whether to write the last-applied-configuration annotation.
+	showDiff             = flag.Bool("diff", env.GetOr("DIFF", strconv.ParseBool, false), "Whether to show a diff between the live objects and the generated manifest before the apply prompt. Defaults to false.") // Whether to show a diff against the live objects.
+	explainDiffFlag      = flag.Bool("explain-diff", env.GetOr("EXPLAIN_DIFF", strconv.ParseBool, false), "Whether to show the diff against the live objects together with a short plain-English summary of it from the model. Defaults to false.") // Whether to summarize the diff in plain English.
+	fix                  = flag.Bool("fix", env.GetOr("FIX", strconv.ParseBool, false), "Whether to generate a corrected manifest for a failing workload from diagnostic input piped to stdin or gathered with --from-pod. Defaults to false.") // Whether to generate a fix for a failing workload.
+	fromPod              = flag.String("from-pod", env.GetOr("FROM_POD", env.String, ""), "The failing pod whose spec, status and recent events are added to the prompt with --fix.") // The failing pod to gather diagnostics from.
+	mode                 = flag.String("mode", env.GetOr("MODE", env.String, ""), "What to do with the generated manifest, preview (print it only), confirm (ask before applying) or auto-apply. Supersedes --raw and --require-confirmation.") // What to do with the generated manifest.
+	dry                  = flag.Bool("dry", env.GetOr("DRY", strconv.ParseBool, false), "Alias of --mode=preview, only print the generated manifest. Defaults to false.") // Alias of --mode=preview.
+	ingressTLS           = flag.String("ingress-tls", env.GetOr("INGRESS_TLS", env.String, ""), "The cert-manager ClusterIssuer generated Ingresses use for TLS, e.g. letsencrypt-prod. Ingresses get the cert-manager.io/cluster-issuer annotation and a tls section.") // The cert-manager ClusterIssuer for Ingress TLS.
+	userAgentFlag        = flag.String("user-agent", env.GetOr("USER_AGENT", env.String, ""), "The User-Agent of requests to OpenAI and the Kubernetes API server. Defaults to kubectl-assistant/<version>.") // The User-Agent of OpenAI and Kubernetes requests.
+	batchFile            = flag.String("batch-file", env.GetOr("BATCH_FILE", env.String, ""), "A file with one prompt per line to generate a manifest for each. Empty lines and lines starting with # are skipped.") // A file with one prompt per line.
+	batchOutputDir       = flag.String("batch-output-dir", env.GetOr("BATCH_OUTPUT_DIR", env.String, ""), "The directory the manifests of --batch-file are written to, one file per prompt, instead of applying them.") // The directory for the manifests of the batch file.
+	pinImagesFlag        = flag.Bool("pin-images", env.GetOr("PIN_IMAGES", strconv.ParseBool, false), "Whether to resolve image tags to their digests with a registry query and reference the images by digest. Defaults to false.") // Whether to pin images by digest.
+	maxSchemaTokens      = flag.Int("max-schema-tokens", env.GetOr("MAX_SCHEMA_TOKENS", strconv.Atoi, 0), "The maximum estimated size in tokens of a schema returned to the model with use-k8s-api. Larger schemas are summarized and truncated. 0 disables the limit. Defaults to 0.") // The maximum size of a schema returned to the model.
+	patchType            = flag.String("patch-type", env.GetOr("PATCH_TYPE", env.String, patchTypeApply), "How objects are applied, one of apply (server-side apply), merge (JSON merge patch) or strategic (strategic merge patch). Objects which don't exist yet are created. Defaults to apply.") // How objects are applied.
+	diffIgnore           = flag.StringSlice("diff-ignore", env.GetOr("DIFF_IGNORE", env.ListOf(env.String, ","), []string{}), "The fields left out of diffs in addition to the server managed fields, e.g. metadata.annotations.deployment*. A field starting with ! is shown again, e.g. !status.") // The fields left out of diffs.
+	provider             = flag.String("provider", env.GetOr("PROVIDER", env.String, ""), "The provider of the OpenAI endpoint, one of openai, azure or local for other OpenAI-compatible endpoints. Defaults to the provider derived from the openai-endpoint.") // The provider of the OpenAI endpoint.
+	deploymentNamePerProvider = flag.StringToString("deployment-name-per-provider", env.GetOr("DEPLOYMENT_NAME_PER_PROVIDER", env.Map(env.String, "=", env.String, ","), map[string]string{}), "The deployment name used for each provider, e.g. openai=gpt-4o,azure=my-gpt-4o. The model of the active provider is used unless openai-deployment-name is given on the command line.") // The deployment name used for each provider.
+	kubectlDiffFlag      = flag.Bool("kubectl-diff", env.GetOr("KUBECTL_DIFF", strconv.ParseBool, false), "Whether to show the output of kubectl diff for the generated manifest before the apply prompt, instead of the built-in diff. Requires kubectl. Defaults to false.") // Whether to show kubectl diff before the apply prompt.
+	protectedContexts    = flag.StringSlice("protected-contexts", env.GetOr("PROTECTED_CONTEXTS", env.ListOf(env.String, ","), []string{}), "The contexts which require typing the context name before applying, even without require-confirmation. * matches any characters, e.g. *prod*.") // The contexts which require a typed confirmation.
+	cache                = flag.Bool("cache", env.GetOr("CACHE", strconv.ParseBool, false), "Whether to cache responses in the user's cache directory, so identical requests return the cached manifest without calling the API. Defaults to false.") // Whether to cache responses.
+	noCache              = flag.Bool("no-cache", false, "Whether to bypass the response cache for this run, the new response is still cached. Defaults to false.") // Whether to bypass the response cache.
+	cacheTTL             = flag.Duration("cache-ttl", env.GetOr("CACHE_TTL", time.ParseDuration, 24*time.Hour), "How long cached responses are used, e.g. 1h. 0 keeps them forever. Defaults to 24h.") // How long cached responses are used.
+	requiredLabels       = flag.StringToString("labels", env.GetOr("LABELS", env.Map(env.String, "=", env.String, ","), map[string]string{}), "The labels added to every applied object and its pod template, e.g. team=payments,env=prod.") // The labels added to every applied object.
+	labelMerge           = flag.String("label-merge", env.GetOr("LABEL_MERGE", env.String, labelMergeFail), "What happens if an object already has one of the labels with a different value, one of fail, overwrite or keep. Defaults to fail.") // How conflicting labels are merged.
+	progress             = flag.String("progress", env.GetOr("PROGRESS", env.String, progressInteractive), "How progress is shown, interactive shows a spinner and banners, plain prints a timestamped line per phase for CI logs. Defaults to interactive.") // How progress is shown.
+	checkReferences      = flag.Bool("check-references", env.GetOr("CHECK_REFERENCES", strconv.ParseBool, false), "Whether to warn before the apply about Secrets, ConfigMaps, ServiceAccounts and PersistentVolumeClaims the workloads reference but neither the manifest nor the target namespace contain. Defaults to false.") // Whether to warn about dangling references.
+	summaryOnly          = flag.Bool("summary-only", env.GetOr("SUMMARY_ONLY", strconv.ParseBool, false), "Whether to show only the kind, name and namespace of every generated object before the apply prompt instead of the full manifest. Defaults to false.") // Whether to show only a summary of the generated objects.
+	approvalWebhook      = flag.String("approval-webhook", env.GetOr("APPROVAL_WEBHOOK", env.String, ""), "The URL of a webhook which approves the apply instead of the terminal prompt, for headless environments. It gets the manifest posted and answers with apply or dont-apply.") // The webhook which approves the apply.
+	approvalTimeout      = flag.Duration("approval-timeout", env.GetOr("APPROVAL_TIMEOUT", time.ParseDuration, 10*time.Minute), "How long to wait for the approval webhook to answer, e.g. 30m. Defaults to 10m.") // How long to wait for the approval webhook.
+	upgradeAPIs          = flag.Bool("upgrade-api-versions", env.GetOr("UPGRADE_API_VERSIONS", strconv.ParseBool, false), "Whether to rewrite deprecated apiVersions of the generated manifest to the versions the cluster serves without asking. Defaults to false.") // Whether to rewrite deprecated apiVersions without asking.
+	outputHeader         = flag.String("manifest-header", env.GetOr("MANIFEST_HEADER", env.String, ""), "A comment block put on top of the manifests written to output-file, or @ followed by the path of a file with it. {{date}} is replaced with the current date.") // The comment block on top of written manifests.
+	images               = flag.StringSlice("image", []string{}, "Images like architecture diagrams to generate the manifest from, as paths to PNG, JPEG, GIF or WebP files. Requires a vision model.") // Images to generate the manifest from.
+	maxManifestBytes     = flag.Int("max-manifest-bytes", env.GetOr("MAX_MANIFEST_BYTES", strconv.Atoi, 1<<20), "The largest generated manifest in bytes, larger manifests abort the run before they are decoded or applied. 0 turns the limit off. Defaults to 1048576 (1 MiB).") // The largest generated manifest in bytes.
+	gracePeriod          = flag.Int("grace-period", env.GetOr("GRACE_PERIOD", strconv.Atoi, -1), "The seconds objects are given to terminate gracefully with delete, 0 deletes them immediately. Defaults to -1, the grace period of the object.") // The grace period used with delete.
+	stream               = flag.Bool("stream", env.GetOr("STREAM", strconv.ParseBool, false), "Whether to stream the manifest to stderr while the model generates it, instead of showing the spinner. Requires the chat completion API. Defaults to false.") // Whether to stream the manifest while it is generated.
+	noFunctions          = flag.Bool("no-functions", env.GetOr("NO_FUNCTIONS", strconv.ParseBool, false), "Whether to keep the instructions of use-k8s-api in the prompt but not offer the model the functions to look up the schemas, e.g. to compare both. Defaults to false.") // Whether to leave out the functions of use-k8s-api.
+	traceIDFlag          = flag.String("trace-id", env.GetOr("TRACE_ID", env.String, ""), "The id added to every log line, audit log entry and structured output of a run, to correlate them. Defaults to a random id per run.") // The id which correlates the logs of a run.
+	batchDefaults        = flag.Bool("batch-defaults", env.GetOr("BATCH_DEFAULTS", strconv.ParseBool, false), "Whether to make sure generated Jobs and CronJobs have a restartPolicy of OnFailure or Never and a backoffLimit, warning about every fix. Defaults to false.") // Whether to fix the restart and backoff settings of Jobs.
+	batchBackoffLimit    = flag.Int("batch-backoff-limit", env.GetOr("BATCH_BACKOFF_LIMIT", strconv.Atoi, 3), "The backoffLimit batch-defaults sets on Jobs and CronJobs without one. Defaults to 3.") // The backoffLimit of batch-defaults.
+	validateEndpoint     = flag.Bool("validate-endpoint", env.GetOr("VALIDATE_ENDPOINT", strconv.ParseBool, false), "Whether to check that the openai-endpoint is reachable and accepts the key with a request listing the models, before the prompt is sent. Defaults to false.") // Whether to check the OpenAI endpoint before the prompt is sent.
+	formatFlag           = flag.String("format", env.GetOr("FORMAT", env.String, ""), "What to generate instead of a manifest. Set to helm-chart to write a minimal Helm chart to the output-file directory, it is never applied.") // What to generate instead of a manifest.
+	retryOnStatus        = flag.IntSlice("retry-on-status", env.GetOr("RETRY_ON_STATUS", env.ListOf(strconv.Atoi, ","), []int{http.StatusTooManyRequests}), "The HTTP status codes of failed requests to the OpenAI endpoint which are retried with backoff, e.g. 429,503 for gateways which rate limit with 503. Defaults to 429.") // The status codes of failed requests which are retried.
+	retryOnError         = flag.StringSlice("retry-on-error", env.GetOr("RETRY_ON_ERROR", env.ListOf(env.String, ","), []string{}), "Failed requests to the OpenAI endpoint whose error message contains one of these texts are retried with backoff too, case-insensitively, e.g. for gateways with custom rate limit responses.") // The error messages of failed requests which are retried.
+	createNamespace      = flag.Bool("create-namespace", env.GetOr("CREATE_NAMESPACE", strconv.ParseBool, false), "Whether to create the namespaces the objects are applied to if they don't exist yet, like helm install --create-namespace. Defaults to false.") // Whether to create missing namespaces before applying.
+	batchConcurrency     = flag.Int("batch-concurrency", env.GetOr("BATCH_CONCURRENCY", strconv.Atoi, 1), "How many manifests of --batch-file are generated at the same time. They are still confirmed, applied or written one after the other. Defaults to 1.") // How many manifests of the batch file are generated at once.
+	batchRateLimit       = flag.Int("batch-requests-per-minute", env.GetOr("BATCH_REQUESTS_PER_MINUTE", strconv.Atoi, 0), "How many requests for the manifests of --batch-file are sent per minute at most, to stay within the rate limits of the account. 0 doesn't limit them. Defaults to 0.") // How many manifests of the batch file are generated per minute.
+	promptSuffix         = flag.String("prompt-suffix", env.GetOr("PROMPT_SUFFIX", env.String, ""), "A text appended to every request, e.g. \"use the latest stable apiVersions and add standard labels\".") // A text appended to every request.
+	serverDryRunFlag     = flag.Bool("server-dry-run", env.GetOr("SERVER_DRY_RUN", strconv.ParseBool, false), "Whether to apply the manifest with a server-side dry-run before the apply prompt, so denials of the admission webhooks of the cluster are shown before anything is applied. Defaults to false.") // Whether to dry-run the manifest on the server before the apply prompt.
+	onImmutable          = flag.String("on-immutable", env.GetOr("ON_IMMUTABLE", env.String, onImmutableFail), "What happens if applying an object fails because it changes an immutable field, one of fail or replace. With replace the object is deleted and created again if the apply and the replacement were confirmed. Defaults to fail.") // What happens when an immutable field is changed.
+	validateReport       = flag.Bool("validate", env.GetOr("VALIDATE", strconv.ParseBool, false), "Whether to validate the generated manifest against the Kubernetes OpenAPI schema and lint it instead of applying it. With --output=json the report is printed as JSON. Exits non-zero if the manifest is invalid. Defaults to false.") // Whether to validate the manifest instead of applying it.
+	otelEndpoint         = flag.String("otel-endpoint", env.GetOr("OTEL_ENDPOINT", env.String, ""), "The OTLP/HTTP endpoint of an OpenTelemetry collector, e.g. http://localhost:4318. Every generation and apply is exported to it as a span with the model, token usage, object count and outcome.") // The OpenTelemetry collector spans are exported to.
+	contextResources     = flag.StringSlice("context-resources", env.GetOr("CONTEXT_RESOURCES", env.ListOf(env.String, ","), []string{}), "Live objects added to the prompt as context, e.g. deployment/api,service/api. Every object is fetched from the cluster and added as YAML without the fields managed by the server.") // Live objects added to the prompt.
+	ha                   = flag.Bool("ha", env.GetOr("HA", strconv.ParseBool, false), "Whether to generate highly available workloads, Deployments and StatefulSets without topology spread constraints or pod anti-affinity get a topology spread constraint across nodes, warning about every fix. Defaults to false.") // Whether to generate highly available workloads.
+	forceChat            = flag.Bool("force-chat", env.GetOr("FORCE_CHAT", strconv.ParseBool, false), "Alias of --completion-api=chat, never use the deprecated completions API. Legacy models like text-davinci-003 which only the completions API serves fail right away with a clear error. Defaults to false.") // Alias of --completion-api=chat.
+	imagePullSecrets     = flag.StringSlice("image-pull-secret", env.GetOr("IMAGE_PULL_SECRET", env.ListOf(env.String, ","), []string{}), "The image pull secrets added to the pod spec of every workload and to every ServiceAccount of the manifest, e.g. regcred. Can be given more than once.") // The image pull secrets added to every pod spec.
+	templateName         = flag.String("template", env.GetOr("TEMPLATE", env.String, ""), "The name of a template in the template directory, e.g. web-service for web-service.yaml. The template is the skeleton of the manifest which the model fills in from the prompt.") // The name of the template of the manifest.
+	templateDir          = flag.String("template-dir", env.GetOr("TEMPLATE_DIR", env.String, defaultTemplateDir()), "The directory of the templates of the template flag.") // The directory of the templates.
+	noNetwork            = flag.Bool("no-network", env.GetOr("NO_NETWORK", strconv.ParseBool, false), "Whether to run fully offline, e.g. for air-gapped demos: the schema is read from the local file of k8s-openapi-url, manifests come from the response cache and only local clusters are used. Fails if one of them is missing. Defaults to false.") // Whether to run fully offline.
 	debug                = flag.Bool("debug", env.GetOr("DEBUG", strconv.ParseBool, false), "Whether to print debug logs. Defaults to false.")                                                                                                                                                     // Whether to print debug logs.
 )
 
 // InitAndExecute initializes the application and executes the root command.
-// It checks if the OpenAI key is provided and exits if it is not.
-// It then executes the root command.
+// The commands which call OpenAI check that the key is provided, see requireAPIKey.
 //this is the function that's being called from main.go file
 func InitAndExecute() {
-	if *openAIAPIKey == "" {
-		fmt.Println("Please provide an OpenAI key.")
+	log.AddHook(traceHook{})
+	cmd := RootCmd()
+	//a prompt may start with the name of a subcommand, e.g. review my deployment manifest, then it's
+	//run without subcommands so cobra doesn't dispatch it
+	if promptArgs(cmd, os.Args[1:]) {
+		cmd.ResetCommands()
+	}
+	if err := cmd.Execute(); err != nil {
 		os.Exit(1)
 	}
+}
 
-	if err := RootCmd().Execute(); err != nil {
-		os.Exit(1)
+// requireAPIKey returns an error if no OpenAI key is provided, init is how the key gets into the config file
+// in the first place, so it doesn't need one.
+func requireAPIKey() error {
+	if *openAIAPIKey == "" {
+		return fmt.Errorf("please provide an OpenAI key, or run kubectl-assistant init")
+	}
+	return nil
+}
+
+// promptArgs reports whether the arguments are a prompt rather than a subcommand. Arguments only run a
+// subcommand if its name comes first and the subcommand accepts the rest of them, e.g. describe deployment api,
+// otherwise they are the prompt, e.g. describe the nginx deployment or init a redis statefulset.
+func promptArgs(root *cobra.Command, args []string) bool {
+	positional := positionalArgs(root, args)
+	if len(positional) == 0 {
+		return false
+	}
+	//help only takes the names of subcommands, help me create a deployment is a prompt
+	if positional[0] == "help" {
+		if len(positional) == 1 {
+			return false
+		}
+		sub, _, err := root.Find(positional[1:])
+		return err != nil || sub == root
 	}
+	sub, rest, err := root.Find(positional)
+	if err != nil || sub == root || !sub.Runnable() {
+		return true
+	}
+	return sub.ValidateArgs(rest) != nil
+}
+
+// positionalArgs returns the arguments which aren't flags or values of flags, without parsing the flags,
+// parsing them twice would append the values of slice flags twice.
+func positionalArgs(root *cobra.Command, args []string) []string {
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(positional, args[i+1:]...)
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			continue
+		}
+		if strings.Contains(arg, "=") {
+			continue
+		}
+		var f *flag.Flag
+		if name, ok := strings.CutPrefix(arg, "--"); ok {
+			f = lookupFlag(root, name)
+		} else if len(arg) == 2 {
+			f = root.PersistentFlags().ShorthandLookup(arg[1:])
+			if f == nil {
+				f = flag.CommandLine.ShorthandLookup(arg[1:])
+			}
+		}
+		//the value of a flag like --model gpt-4o is the next argument, boolean flags have no value
+		if f != nil && f.NoOptDefVal == "" {
+			i++
+		}
+	}
+	return positional
+}
+
+// lookupFlag returns the flag of the root command or one of its subcommands with the name.
+func lookupFlag(root *cobra.Command, name string) *flag.Flag {
+	if f := flag.CommandLine.Lookup(name); f != nil {
+		return f
+	}
+	if f := root.PersistentFlags().Lookup(name); f != nil {
+		return f
+	}
+	for _, sub := range root.Commands() {
+		if f := sub.Flags().Lookup(name); f != nil {
+			return f
+		}
+	}
+	return nil
 }
 
 //being called from InitAndExecute function above (which is in turn called at main.go)
@@ -66,7 +264,13 @@ func RootCmd() *cobra.Command {
 		Long:         "kubectl-assistant is a plugin for kubectl that allows you to interact with OpenAI GPT API.",
 		Version:      version,
 		SilenceUsage: true,
-		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		//with subcommands cobra would reject the words of the prompt as unknown commands
+		Args: cobra.ArbitraryArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			//the config file written by init holds the defaults, flags and environment variables override them
+			if err := loadConfig(); err != nil {
+				return err
+			}
 			// Set the log level to debug if the debug flag is enabled
 //we're checking if debuf flag is enabled, then we will set log level as debuglevel
 //and we will call the print debug flags function that prints the debug flags
@@ -76,15 +280,97 @@ func RootCmd() *cobra.Command {
 				log.SetLevel(log.DebugLevel)
 				printDebugFlags()
 			}
+			//the prompts use unicode icons too, replace them for terminals without unicode support
+			if *ascii {
+				promptui.IconSelect = promptui.Styler(promptui.FGBold)(">")
+				promptui.IconGood = promptui.Styler(promptui.FGGreen)("+")
+				promptui.IconWarn = promptui.Styler(promptui.FGYellow)("!")
+				promptui.IconBad = promptui.Styler(promptui.FGRed)("x")
+			}
+			return nil
+		},
+		//PreRunE runs after PersistentPreRun and before RunE, if it returns an error
+		//we stop right away instead of failing somewhere deep in the pipeline
+		PreRunE: func(_ *cobra.Command, _ []string) error {
+			//resume applies the stored manifest without calling the model, e.g. on a runner without an API key
+			if !*resume {
+				if err := requireAPIKey(); err != nil {
+					return err
+				}
+			}
+			if err := resolveMode(); err != nil {
+				return err
+			}
+			resolveDeploymentName()
+			if !*resume {
+				if err := resolveCompletionAPI(); err != nil {
+					return err
+				}
+			}
+			//with no-network every source has to be offline, see offline.go
+			if err := resolveNoNetwork(); err != nil {
+				return err
+			}
+			return validateFlags()
 		},
 		RunE: func(_ *cobra.Command, args []string) error {
+			//with otel-endpoint the spans are exported until the command is done, see otel.go
+			defer startTelemetry()()
 //the prompt we need to run is accessible via the args variable
 //if the length of args is zero, means there is no prompt provided <kubectl something something>
-			// Check if a prompt is provided
-			if len(args) == 0 {
+			//with from-history the prompt is picked from the history instead of the arguments
+			if *fromHistory {
+				prompt, err := selectFromHistory()
+				if err != nil {
+					return err
+				}
+				args = []string{prompt}
+			}
+			//resuming continues with the manifest of the interrupted run, see resume.go
+			if *resume {
+				ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+				defer cancel()
+				return resumeApply(ctx)
+			}
+			//with fix the diagnostic input piped to stdin is part of the prompt, it can only be read once
+			//so it's read here and not in run, see fix.go
+			if *fix {
+				diagnostics, err := readDiagnosticInput()
+				if err != nil {
+					return err
+				}
+				fixDiagnostics = diagnostics
+				if diagnostics == "" && *fromPod == "" {
+					return fmt.Errorf("--fix requires diagnostic input on stdin or --from-pod")
+				}
+				//fail before generating if the apply could never be confirmed
+				if *requireConfirmation && *approvalWebhook == "" {
+					if _, err := answerReader(); err != nil {
+						return err
+					}
+				}
+			}
+			//the template is read once for every generation of the run, see templates.go
+			if err := loadTemplate(); err != nil {
+				return err
+			}
+			//in batch mode the prompts come from the batch file, see batch.go
+			if *batchFile != "" {
+				return runBatch(args)
+			}
+			// Check if a prompt is provided, either as arguments or with the prompt-file flag
+			if len(args) == 0 && *promptFile == "" && !*fix && len(*images) == 0 {
 				return fmt.Errorf("prompt must be provided")
 			}
 //if lenght of args is not zero and there's actually a value, we proceed
+			//a broken history file shouldn't stop us from generating the manifest
+			if err := recordPrompt(strings.Join(args, " ")); err != nil {
+				log.Debugf("unable to record prompt: %v", err)
+			}
+			//in watch mode the run function is called again every time the prompt file changes
+			if *watch {
+				return watchPromptFile(args)
+			}
 			// Run the main logic of the CLI
 //this is the main part of this function, where we essentially call the run function
 			err := run(args) //calling the run function defined below
@@ -101,6 +387,13 @@ func RootCmd() *cobra.Command {
 	// Add Kubernetes configuration flags to the command
 	kubernetesConfigFlags.AddFlags(cmd.PersistentFlags())
 
+	//the prompt is given as arguments, arguments the subcommands don't accept are the prompt, see promptArgs
+	cmd.CompletionOptions.DisableDefaultCmd = true
+	cmd.AddCommand(undoCmd())
+	cmd.AddCommand(initCmd())
+	cmd.AddCommand(describeCmd())
+	cmd.AddCommand(reviewCmd())
+
 	return cmd //cmd is of type cobra.Command, a struct in the cobra package
 }
 
@@ -115,15 +408,443 @@ func printDebugFlags() {
 	log.Debugf("temperature: %f", *temperature)
 	log.Debugf("use-k8s-api: %t", *usek8sAPI)
 	log.Debugf("k8s-openapi-url: %s", *k8sOpenAPIURL)
+	log.Debugf("targeted-schema: %t", *targetedSchema)
+	log.Debugf("clarify: %t", *clarify)
+	log.Debugf("no-spinner: %t", *noSpinner)
+	log.Debugf("explain-rbac: %t", *explainRBAC)
+	log.Debugf("prompt-file: %s", *promptFile)
+	log.Debugf("watch: %t", *watch)
+	log.Debugf("output-file: %s", *outputFile)
+	log.Debugf("split: %t", *split)
+	log.Debugf("cloud: %s", *cloud)
+	log.Debugf("skip-unchanged: %t", *skipUnchanged)
+	log.Debugf("prompt-history-file: %s", *historyFile)
+	log.Debugf("from-history: %t", *fromHistory)
+	log.Debugf("no-history: %t", *noHistory)
+	log.Debugf("delete: %t", *deleteMode)
+	log.Debugf("delete-propagation: %s", *deletePropagation)
+	log.Debugf("name-prefix: %s", *namePrefix)
+	log.Debugf("name-suffix: %s", *nameSuffix)
+	log.Debugf("retry-max-duration: %s", *retryMaxDuration)
+	log.Debugf("production: %t", *production)
+	log.Debugf("output: %s", *output)
+	log.Debugf("audit-log: %s", *auditLog)
+	log.Debugf("only: %v", *only)
+	log.Debugf("kube-server: %s", *kubeServer)
+	log.Debugf("kube-insecure: %t", *kubeInsecure)
+	log.Debugf("schema-fix: %t", *schemaFix)
+	log.Debugf("completion-api: %s", *completionAPI)
+	log.Debugf("context-namespace-default: %s", *contextNamespaceDefault)
+	log.Debugf("annotate: %t", *annotate)
+	log.Debugf("context-window: %d", *contextWindow)
+	log.Debugf("confirm-each: %t", *confirmEach)
+	log.Debugf("azure-openai-map-file: %s", *azureModelMapFile)
+	log.Debugf("strict-yaml: %t", *strictYAML)
+	log.Debugf("from-file: %v", *fromFile)
+	log.Debugf("resume: %t", *resume)
+	log.Debugf("lint: %t", *lint)
+	log.Debugf("ascii: %t", *ascii)
+	log.Debugf("spinner-charset: %v", *spinnerFrames)
+	log.Debugf("test-namespace: %t", *testNamespace)
+	log.Debugf("yaml-indent: %d", *yamlIndent)
+	log.Debugf("yaml-key-order: %s", *yamlKeyOrder)
+	log.Debugf("track-last-applied: %t", *trackLastApplied)
+	log.Debugf("diff: %t", *showDiff)
+	log.Debugf("explain-diff: %t", *explainDiffFlag)
+	log.Debugf("fix: %t", *fix)
+	log.Debugf("from-pod: %s", *fromPod)
+	log.Debugf("mode: %s", *mode)
+	log.Debugf("dry: %t", *dry)
+	log.Debugf("ingress-tls: %s", *ingressTLS)
+	log.Debugf("user-agent: %s", userAgent())
+	log.Debugf("batch-file: %s", *batchFile)
+	log.Debugf("batch-output-dir: %s", *batchOutputDir)
+	log.Debugf("pin-images: %t", *pinImagesFlag)
+	log.Debugf("max-schema-tokens: %d", *maxSchemaTokens)
+	log.Debugf("patch-type: %s", *patchType)
+	log.Debugf("diff-ignore: %v", *diffIgnore)
+	log.Debugf("provider: %s", *provider)
+	log.Debugf("deployment-name-per-provider: %v", *deploymentNamePerProvider)
+	log.Debugf("kubectl-diff: %t", *kubectlDiffFlag)
+	log.Debugf("protected-contexts: %v", *protectedContexts)
+	log.Debugf("cache: %t", *cache)
+	log.Debugf("no-cache: %t", *noCache)
+	log.Debugf("cache-ttl: %s", *cacheTTL)
+	log.Debugf("labels: %v", *requiredLabels)
+	log.Debugf("label-merge: %s", *labelMerge)
+	log.Debugf("progress: %s", *progress)
+	log.Debugf("check-references: %t", *checkReferences)
+	log.Debugf("summary-only: %t", *summaryOnly)
+	log.Debugf("approval-webhook: %s", *approvalWebhook)
+	log.Debugf("approval-timeout: %s", *approvalTimeout)
+	log.Debugf("upgrade-api-versions: %t", *upgradeAPIs)
+	log.Debugf("manifest-header: %s", *outputHeader)
+	log.Debugf("image: %v", *images)
+	log.Debugf("max-manifest-bytes: %d", *maxManifestBytes)
+	log.Debugf("grace-period: %d", *gracePeriod)
+	log.Debugf("stream: %t", *stream)
+	log.Debugf("no-functions: %t", *noFunctions)
+	log.Debugf("trace-id: %s", *traceIDFlag)
+	log.Debugf("batch-defaults: %t", *batchDefaults)
+	log.Debugf("batch-backoff-limit: %d", *batchBackoffLimit)
+	log.Debugf("validate-endpoint: %t", *validateEndpoint)
+	log.Debugf("format: %s", *formatFlag)
+	log.Debugf("retry-on-status: %v", *retryOnStatus)
+	log.Debugf("retry-on-error: %v", *retryOnError)
+	log.Debugf("create-namespace: %t", *createNamespace)
+	log.Debugf("batch-concurrency: %d", *batchConcurrency)
+	log.Debugf("batch-requests-per-minute: %d", *batchRateLimit)
+	log.Debugf("prompt-suffix: %s", *promptSuffix)
+	log.Debugf("server-dry-run: %t", *serverDryRunFlag)
+	log.Debugf("on-immutable: %s", *onImmutable)
+	log.Debugf("validate: %t", *validateReport)
+	log.Debugf("otel-endpoint: %s", *otelEndpoint)
+	log.Debugf("context-resources: %v", *contextResources)
+	log.Debugf("ha: %t", *ha)
+	log.Debugf("force-chat: %t", *forceChat)
+	log.Debugf("image-pull-secret: %v", *imagePullSecrets)
+	log.Debugf("template: %s", *templateName)
+	log.Debugf("template-dir: %s", *templateDir)
+	log.Debugf("no-network: %t", *noNetwork)
+}
+
+// values of the mode flag
+const (
+	modePreview   = "preview"
+	modeConfirm   = "confirm"
+	modeAutoApply = "auto-apply"
+)
+
+// resolveMode sets the raw and require-confirmation flags from the mode flag, which supersedes them.
+// The precedence is --mode, then --dry (an alias of --mode=preview), then --raw, then --require-confirmation.
+// Without --mode and --dry the old flags keep working as they always did.
+func resolveMode() error {
+	m := *mode
+	if m == "" && *dry {
+		m = modePreview
+	}
+	switch m {
+	case "":
+		return nil
+	case modePreview:
+		*raw = true
+	case modeConfirm:
+		*raw = false
+		*requireConfirmation = true
+	case modeAutoApply:
+		*raw = false
+		*requireConfirmation = false
+	default:
+		return fmt.Errorf("--mode must be one of %s, %s or %s, got %q", modePreview, modeConfirm, modeAutoApply, m)
+	}
+	log.Debugf("mode %s: raw: %t, require-confirmation: %t", m, *raw, *requireConfirmation)
+	return nil
+}
+
+// validateFlags checks the flags for combinations that contradict each other.
+// It returns an error describing the first incompatible combination found.
+func validateFlags() error {
+	if !slices.Contains([]string{completionAPIAuto, completionAPIChat, completionAPICompletions, completionAPIResponses}, *completionAPI) {
+		return fmt.Errorf("--completion-api must be one of %s, %s, %s or %s, got %q", completionAPIAuto, completionAPIChat, completionAPICompletions, completionAPIResponses, *completionAPI)
+	}
+	if !slices.Contains([]string{"", providerOpenAI, providerAzure, providerLocal}, *provider) {
+		return fmt.Errorf("--provider must be one of %s, %s or %s, got %q", providerOpenAI, providerAzure, providerLocal, *provider)
+	}
+	for p := range *deploymentNamePerProvider {
+		if !slices.Contains([]string{providerOpenAI, providerAzure, providerLocal}, p) {
+			return fmt.Errorf("--deployment-name-per-provider has unknown provider %q, must be one of %s, %s or %s", p, providerOpenAI, providerAzure, providerLocal)
+		}
+	}
+	if *noFunctions && !*usek8sAPI {
+		return fmt.Errorf("--no-functions requires --use-k8s-api")
+	}
+	//function calling is only available with the chat completion API, so the k8s API
+	//can't be used with the legacy non-chat models
+	if *usek8sAPI && !*noFunctions && useCompletionsAPI(*openAIDeploymentName) {
+		return fmt.Errorf("--use-k8s-api requires the chat completion API with function calling, but %q uses the completions API", *openAIDeploymentName)
+	}
+	if *usek8sAPI && !*noFunctions && *completionAPI == completionAPIResponses {
+		return fmt.Errorf("--use-k8s-api requires the chat completion API with function calling, it can't be used with the responses API")
+	}
+	//only the chat completion API is streamed, see stream.go
+	if *stream && (useCompletionsAPI(*openAIDeploymentName) || *completionAPI == completionAPIResponses) {
+		return fmt.Errorf("--stream requires the chat completion API")
+	}
+	//images are only sent with chat messages, see image.go
+	if len(*images) > 0 && (useCompletionsAPI(*openAIDeploymentName) || *completionAPI == completionAPIResponses) {
+		return fmt.Errorf("--image requires a vision model and the chat completion API")
+	}
+	for _, path := range *images {
+		if _, err := readImage(path); err != nil {
+			return err
+		}
+	}
+	//Azure OpenAI exposes the Responses API under a different path and API version
+	if *completionAPI == completionAPIResponses && getProvider() == providerAzure {
+		return fmt.Errorf("--completion-api=responses is not supported with Azure OpenAI endpoints")
+	}
+	//the accepted temperature range depends on the provider, we don't want to silently send
+	//a value the provider rejects or treats differently
+	if maxTemperature := getMaxTemperature(getProvider()); *temperature < 0 || *temperature > maxTemperature {
+		return fmt.Errorf("--temperature must be between 0 and %g for the %s provider, got %g", maxTemperature, getProvider(), *temperature)
+	}
+	if *temperature != 0 && !acceptsTemperature(*openAIDeploymentName) {
+		log.Warnf("%s doesn't accept a temperature, --temperature is ignored", *openAIDeploymentName)
+	}
+	if err := validateCloud(*cloud); err != nil {
+		return err
+	}
+	//we can't select a prompt without a history
+	if *fromHistory && *historyFile == "" {
+		return fmt.Errorf("--from-history requires --prompt-history-file")
+	}
+	//raw and output-file never touch the cluster, so there would be nothing deleted
+	if *deleteMode && (*raw || *outputFile != "") {
+		return fmt.Errorf("--delete can't be used together with --raw or --output-file")
+	}
+	//the propagation policy is only used when deleting, a DELETE_PROPAGATION exported for deletes is ignored
+	//by the other runs, only the flag is rejected
+	if flag.CommandLine.Changed("delete-propagation") && !*deleteMode {
+		return fmt.Errorf("--delete-propagation requires --delete")
+	}
+	if !slices.Contains([]string{"", string(metav1.DeletePropagationForeground), string(metav1.DeletePropagationBackground), string(metav1.DeletePropagationOrphan)}, *deletePropagation) {
+		return fmt.Errorf("--delete-propagation must be one of Foreground, Background or Orphan, got %q", *deletePropagation)
+	}
+	if *batchConcurrency < 1 || *batchRateLimit < 0 {
+		return fmt.Errorf("--batch-concurrency must be at least 1 and --batch-requests-per-minute can't be negative")
+	}
+	if (*batchConcurrency > 1 || *batchRateLimit > 0) && *batchFile == "" {
+		return fmt.Errorf("--batch-concurrency and --batch-requests-per-minute require --batch-file")
+	}
+	//the streamed manifests of concurrent prompts would be interleaved
+	if *stream && *batchConcurrency > 1 {
+		return fmt.Errorf("--stream can't be used together with --batch-concurrency")
+	}
+	if *serverDryRunFlag && *deleteMode {
+		return fmt.Errorf("--server-dry-run can't be combined with --delete")
+	}
+	if *createNamespace && *deleteMode {
+		return fmt.Errorf("--create-namespace can't be used together with --delete")
+	}
+	//like kubectl delete, -1 uses the grace period of the object and 0 deletes immediately
+	//a GRACE_PERIOD exported for teardown runs is ignored by the other runs, only the flag is rejected
+	if flag.CommandLine.Changed("grace-period") && !*deleteMode {
+		return fmt.Errorf("--grace-period requires --delete")
+	}
+	if *gracePeriod < -1 {
+		return fmt.Errorf("--grace-period must be -1 or more, got %d", *gracePeriod)
+	}
+	if *batchBackoffLimit < 0 {
+		return fmt.Errorf("--batch-backoff-limit can't be negative")
+	}
+	if *maxManifestBytes < 0 {
+		return fmt.Errorf("--max-manifest-bytes can't be negative")
+	}
+	for _, status := range *retryOnStatus {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("--retry-on-status must be HTTP status codes, got %d", status)
+		}
+	}
+	if *retryMaxDuration < 0 {
+		return fmt.Errorf("--retry-max-duration can't be negative")
+	}
+	//fail before calling the model if a file of from-file can't be read
+	sources, err := parseFileSources(*fromFile)
+	if err != nil {
+		return err
+	}
+	for _, source := range sources {
+		if _, err := os.Stat(source.path); err != nil {
+			return fmt.Errorf("unable to read --from-file: %w", err)
+		}
+	}
+	//resuming applies the saved manifest, nothing is generated
+	if *resume && (*watch || *fromHistory || *raw || *outputFile != "" || *output == outputJSON) {
+		return fmt.Errorf("--resume can't be combined with --watch, --from-history, --raw, --output-file or --output=json")
+	}
+	if (*showDiff || *explainDiffFlag || *kubectlDiffFlag) && *deleteMode {
+		return fmt.Errorf("--diff, --explain-diff and --kubectl-diff can't be combined with --delete")
+	}
+	//kubectl doesn't know about the kube-server flag
+	if *kubectlDiffFlag && *kubeServer != "" {
+		return fmt.Errorf("--kubectl-diff can't be combined with --kube-server")
+	}
+	//a FROM_POD in the environment is ignored without fix, only the flag is rejected
+	if flag.CommandLine.Changed("from-pod") && !*fix {
+		return fmt.Errorf("--from-pod requires --fix")
+	}
+	if *testNamespace && *deleteMode {
+		return fmt.Errorf("--test-namespace can't be combined with --delete")
+	}
+	if !slices.Contains([]string{"", keyOrderKubernetes, keyOrderAlphabetical}, *yamlKeyOrder) {
+		return fmt.Errorf("--yaml-key-order must be %s or %s, got %q", keyOrderKubernetes, keyOrderAlphabetical, *yamlKeyOrder)
+	}
+	if *yamlIndent < 0 {
+		return fmt.Errorf("--yaml-indent can't be negative")
+	}
+	if *batchFile != "" && (*watch || *fromHistory || *resume || *fix || *promptFile != "") {
+		return fmt.Errorf("--batch-file can't be combined with --watch, --from-history, --resume, --fix or --prompt-file")
+	}
+	if *batchOutputDir != "" && (*batchFile == "" || *outputFile != "") {
+		return fmt.Errorf("--batch-output-dir requires --batch-file and can't be combined with --output-file")
+	}
+	if !slices.Contains([]string{patchTypeApply, patchTypeMerge, patchTypeStrategic}, *patchType) {
+		return fmt.Errorf("--patch-type must be one of apply, merge or strategic, got %q", *patchType)
+	}
+	if !slices.Contains([]string{progressInteractive, progressPlain}, *progress) {
+		return fmt.Errorf("--progress must be one of interactive or plain, got %q", *progress)
+	}
+	for _, ref := range *contextResources {
+		if resource, name, ok := strings.Cut(ref, "/"); !ok || resource == "" || name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("--context-resources expects kind/name, e.g. deployment/api, got %q", ref)
+		}
+	}
+	if strings.ContainsAny(*templateName, `/\`) {
+		return fmt.Errorf("--template expects the name of a template in --template-dir, got %q", *templateName)
+	}
+	if *templateName != "" && *templateDir == "" {
+		return fmt.Errorf("--template requires --template-dir")
+	}
+	for _, name := range *imagePullSecrets {
+		if name == "" {
+			return fmt.Errorf("--image-pull-secret expects the name of a secret, got an empty name")
+		}
+	}
+	if *otelEndpoint != "" {
+		if _, err := otelTracesURL(*otelEndpoint); err != nil {
+			return fmt.Errorf("--otel-endpoint is invalid: %w", err)
+		}
+	}
+	if *validateReport && (*raw || *outputFile != "" || *deleteMode || *formatFlag == formatHelmChart) {
+		return fmt.Errorf("--validate can't be used together with --raw, --output-file, --delete or --format=%s", formatHelmChart)
+	}
+	if !slices.Contains([]string{onImmutableFail, onImmutableReplace}, *onImmutable) {
+		return fmt.Errorf("--on-immutable must be one of fail or replace, got %q", *onImmutable)
+	}
+	if !slices.Contains([]string{labelMergeFail, labelMergeOverwrite, labelMergeKeep}, *labelMerge) {
+		return fmt.Errorf("--label-merge must be one of fail, overwrite or keep, got %q", *labelMerge)
+	}
+	if *approvalWebhook != "" && !strings.HasPrefix(*approvalWebhook, "http://") && !strings.HasPrefix(*approvalWebhook, "https://") {
+		return fmt.Errorf("--approval-webhook must be an http or https URL, got %q", *approvalWebhook)
+	}
+	if *approvalTimeout < 0 {
+		return fmt.Errorf("--approval-timeout can't be negative")
+	}
+	if *cacheTTL < 0 {
+		return fmt.Errorf("--cache-ttl can't be negative")
+	}
+	if *maxSchemaTokens < 0 {
+		return fmt.Errorf("--max-schema-tokens can't be negative")
+	}
+	if *contextWindow < 0 {
+		return fmt.Errorf("--context-window can't be negative")
+	}
+	//the token and insecure flags only make sense together with a server
+	if (*kubeToken != "" || *kubeInsecure) && *kubeServer == "" {
+		return fmt.Errorf("--kube-token and --kube-insecure require --kube-server")
+	}
+	//like kubectl, a CA file and skipping the verification don't go together
+	if *kubeInsecure && *kubernetesConfigFlags.CAFile != "" {
+		return fmt.Errorf("--kube-insecure can't be used together with --certificate-authority")
+	}
+	//there is nothing to watch without a prompt file
+	if *watch && *promptFile == "" {
+		return fmt.Errorf("--watch requires --prompt-file")
+	}
+	//raw prints the manifest to stdout and output-file writes it to disk, only one of them can be used
+	if *raw && *outputFile != "" {
+		return fmt.Errorf("--raw can't be used together with --output-file")
+	}
+	if *output != "" && *output != outputJSON {
+		return fmt.Errorf("--output must be %s, got %q", outputJSON, *output)
+	}
+	//output=json prints the result to stdout just like raw does
+	if *output == outputJSON && (*raw || *outputFile != "" || *deleteMode) {
+		return fmt.Errorf("--output=%s can't be used together with --raw, --output-file or --delete", outputJSON)
+	}
+	//a Helm chart is only written, never applied
+	if *formatFlag != "" && *formatFlag != formatHelmChart {
+		return fmt.Errorf("--format must be %s, got %q", formatHelmChart, *formatFlag)
+	}
+	if *formatFlag == formatHelmChart && (*outputFile == "" || *raw || *split || *deleteMode || *output == outputJSON || *batchFile != "") {
+		return fmt.Errorf("--format=%s requires --output-file for the chart directory and can't be used together with --raw, --split, --delete, --output or --batch-file", formatHelmChart)
+	}
+	//split writes one file per document into the output-file directory
+	if *split && *outputFile == "" && *batchOutputDir == "" {
+		return fmt.Errorf("--split requires --output-file or --batch-output-dir")
+	}
+	//the header only goes into written files, a header on top of what is applied or printed would be lost
+	if *outputHeader != "" && *outputFile == "" && *batchOutputDir == "" {
+		return fmt.Errorf("--manifest-header requires --output-file or --batch-output-dir")
+	}
+	//the OpenAPI spec is only fetched when the k8s API is used or the manifest is validated, a K8S_OPENAPI_URL
+	//exported for those runs doesn't break the others
+	if flag.CommandLine.Changed("k8s-openapi-url") && !*usek8sAPI && !*validateReport {
+		return fmt.Errorf("--k8s-openapi-url is only used together with --use-k8s-api or --validate")
+	}
+	//the schema is only fetched when the k8s API is used
+	if *schemaFix && !*usek8sAPI {
+		return fmt.Errorf("--schema-fix requires --use-k8s-api")
+	}
+	//targeted schema fetching talks to the cluster, a custom OpenAPI spec URL bypasses it
+	if *targetedSchema && *k8sOpenAPIURL != "" {
+		return fmt.Errorf("--targeted-schema can't be used together with --k8s-openapi-url")
+	}
+	return nil
+}
+
+// requestArgs returns the args of the first generation for the prompt: the prompt file, the diagnostic
+// information of fix and the live objects of context-resources together with the prompt.
+func requestArgs(ctx context.Context, args []string) ([]string, error) {
+	//the prompt file is read on every run, so watch mode picks up the latest content
+	args, err := withPromptFile(args)
+	if err != nil {
+		return nil, err
+	}
+
+	//the diagnostic information goes in front of what the user asked for
+	if *fix {
+		prompt, err := fixPrompt(ctx, fixDiagnostics)
+		if err != nil {
+			return nil, err
+		}
+		args = append([]string{prompt}, args...)
+	}
+	//the live objects the request is about go in front of it, see contextresources.go
+	if len(*contextResources) > 0 {
+		prompt, err := contextResourcesPrompt(ctx)
+		if err != nil {
+			return nil, err
+		}
+		args = append([]string{prompt}, args...)
+	}
+	return args, nil
 }
 
 //main -> initandExecute -> RootCmd -> run function this is how execution is
 // run is the main function that executes the CLI command.
 // It takes a slice of arguments and returns an error if any.
 func run(args []string) error {
-	
+	return runPrefetched(args, nil)
+}
+
+// runPrefetched is run with the manifest of the first generation generated ahead, see prefetchBatchCompletions.
+// Without a prefetched manifest the model is called like for every reprompt.
+func runPrefetched(args []string, prefetched *prefetchedCompletion) error {
+	//every log line and audit entry of this run can be found by its trace id, see trace.go
+	startTrace()
+	log.Debugf("starting run")
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
+	//with otel-endpoint the generations and the apply of the run are exported as spans, see otel.go
+	ctx, span := startSpan(ctx, "run", attribute.String("kubectl_assistant.prompt", spanContent(strings.Join(args, " "))))
+	defer span.End()
+
+	args, err := requestArgs(ctx, args)
+	if err != nil {
+		return err
+	}
 
 	// Create new OAI clients
 //we're calling the function from completion.go file to generate new OpenAIClients
@@ -132,7 +853,21 @@ func run(args []string) error {
 		return err
 	}
 
+	//detect the cloud provider once, not for every reprompt, cloud.go has the details
+	*cloud = resolveCloud(ctx, *cloud)
+
+	//the prompt as the user gave it, recorded in the audit log
+	userPrompt := strings.Join(args, " ")
+
 	var action, completion string
+	//which model generated the completion, see completionInfo in openai.go
+	var info completionInfo
+	//number of clarifying questions the model has asked so far, only used with the clarify flag
+	var questions int
+	//whether the model already had its correction round for the current manifest, only used with the schema-fix flag
+	var schemaFixed bool
+	//the annotations of the annotate flag, the checks before the apply see them too, nil without the flag
+	var annotations map[string]string
 	//user can generate kubectl manifest file and then he needs to take an action, apply it
 	//or not apply and we need to handle both scenarios
 	for action != apply {
@@ -141,43 +876,228 @@ func run(args []string) error {
 
 		// Create a spinner to show processing status
 		//using the go-spinner package to show processing
+		progressf("generating")
 		s := spinner.NewSpinner("Processing...")
-		if !*debug && !*raw {
-			s.SetCharset([]string{"⣾", "⣽", "⣻", "⢿", "⡿", "⣟", "⣯", "⣷"})
+		if spinnerEnabled() {
+			s.SetCharset(spinnerCharset())
 			s.Start()
 		}
 
 // Calling the gptCompletion func. (in completion.go file) by passing oaiClients which we just created above
 //we also pass context, arguments and DeploymentName to this function
 //gptCompletion gives us the response in string format, this func. is defined in completion.go file
-		completion, err = gptCompletion(ctx, oaiClients, args, *openAIDeploymentName)
+		genCtx, genSpan := startSpan(ctx, "generate", attribute.String("gen_ai.request.model", *openAIDeploymentName))
+		if prefetched != nil {
+			log.Debugf("using the manifest generated ahead for the prompt")
+			completion, info, err = prefetched.completion, prefetched.info, prefetched.err
+			//a reprompt calls the model again
+			prefetched = nil
+		} else {
+			//every generation starts with fresh schemas, so CRDs installed since are found
+			schemas.reset()
+			completion, info, err = gptCompletion(genCtx, oaiClients, args, *openAIDeploymentName, func(delay time.Duration, attempt int) {
+				//let the user know we are backing off instead of hanging
+				msg := fmt.Sprintf("Rate limited, retrying in %s... (attempt %d/%d)", delay.Round(time.Second), attempt, maxRetries)
+				if spinnerEnabled() {
+					s.Lock()
+					s.Title = msg
+					s.Unlock()
+				} else {
+					log.Info(msg)
+				}
+			})
+		}
+		genSpan.SetAttributes(generationAttributes(completion, info)...)
+		endSpan(genSpan, outcomeOf(err, "generated"), err)
 		//handling the error for calling the function above
 		if err != nil {
 			return err
 		}
 //s contains the spinner from the go-spinner package, we're stopping it on this line 
 		s.Stop()
+		if err := checkManifestSize(completion); err != nil {
+			return err
+		}
+
+		//with the clarify flag the model may answer with a question instead of YAML,
+		//we ask the user and add the answer to the conversation before generating again
+		if question, ok := clarifyingQuestion(completion); *clarify && ok && questions < maxClarifyingQuestions {
+			questions++
+			answer, err := clarifyingQuestionPrompt(question)
+			if err != nil {
+				return err
+			}
+			args = append(args, fmt.Sprintf("\nQuestion: %s\nAnswer: %s\n", question, answer))
+			if questions == maxClarifyingQuestions {
+				args = append(args, "Do not ask any more questions, generate the manifest now. ")
+			}
+			//we don't want to append an action to the args in the next iteration
+			action = ""
+			continue
+		}
+		//a Helm chart can't be decoded or applied like a manifest, it's written to the output-file directory, see helm.go
+		if *formatFlag == formatHelmChart {
+			if err := writeHelmChart(*outputFile, completion); err != nil {
+				return err
+			}
+			return writeAuditLog(userPrompt, completion, info, auditWritten)
+		}
+		//with schema-fix we validate the manifest against the fetched schema and give the model
+		//one chance to correct the unknown or invalid fields it generated
+		if *schemaFix && !schemaFixed {
+			schemaFixed = true
+			problems, err := validateManifestSchema(completion)
+			if err != nil {
+				log.Debugf("unable to validate manifest: %v", err)
+			} else if len(problems) > 0 {
+				log.Debugf("schema validation errors: %v", problems)
+				args = append(args, fmt.Sprintf(schemaFixInstructions+"%s\nErrors:\n- %s\nFix these errors and generate the complete corrected manifest. ", completion, strings.Join(problems, "\n- ")))
+				action = ""
+				continue
+			}
+		}
+		//with from-file the model only references the files, their content is added here, see fromfile.go
+		if len(*fromFile) > 0 {
+			sources, err := parseFileSources(*fromFile)
+			if err != nil {
+				return err
+			}
+			if completion, err = embedFiles(completion, sources); err != nil {
+				return err
+			}
+		}
+		//models often leave out the backoffLimit or use a restartPolicy Jobs don't accept, see batchdefaults.go
+		if *batchDefaults {
+			if completion, err = addBatchDefaults(completion); err != nil {
+				return err
+			}
+		}
+		//the model often leaves out the anti-affinity of highly available workloads, see ha.go
+		if *ha {
+			if completion, err = addHighAvailability(completion); err != nil {
+				return err
+			}
+		}
+		//the model may drop parts of the template, see templates.go
+		if manifestSkeleton != "" {
+			checkTemplateKinds(completion)
+		}
+		//pin images to their digests before anything is shown or applied, see pin.go
+		if *pinImagesFlag {
+			completion = pinImages(ctx, completion)
+		}
+		//normalize the model's formatting to our YAML style, see format.go
+		if formatEnabled() {
+			if completion, err = formatManifest(completion); err != nil {
+				return err
+			}
+		}
+		if plainProgress() {
+			progressf("generated %d documents", countDocuments(completion))
+		}
+		//with validate the manifest is checked instead of applied, a pipeline can gate on the exit code, see validatereport.go
+		if *validateReport {
+			if err := printValidationReport(completion); err != nil {
+				_ = writeAuditLog(userPrompt, completion, info, auditPrinted)
+				return err
+			}
+			return writeAuditLog(userPrompt, completion, info, auditPrinted)
+		}
 //raw is a flag we've created on the top of this file
 		if *raw {
 //if boolean for the raw flag is true, we print out the completion output received by calling the
 //gptcompletion package above
 			fmt.Println(completion)
-			return nil
+			return writeAuditLog(userPrompt, completion, info, auditPrinted)
+		}
+		//with output=json we print a machine-readable result including the model instead
+		if *output == outputJSON {
+			if err := printJSONOutput(completion, info); err != nil {
+				return err
+			}
+			return writeAuditLog(userPrompt, completion, info, auditPrinted)
+		}
+		//with output-file we write the manifest to disk instead of applying it, writeOutput is in output.go
+		if *outputFile != "" {
+			if err := writeOutput(completion); err != nil {
+				return err
+			}
+			return writeAuditLog(userPrompt, completion, info, auditWritten)
+		}
+		//old apiVersions fail or warn on newer clusters, see apiversions.go
+		if completion, err = upgradeAPIVersions(completion); err != nil {
+			return err
+		}
+		//the model doesn't always follow the instructions, let the user know before applying
+		if *production {
+			warnMissingProductionResources(completion)
+		}
+		if *annotate {
+			annotations = generatedAnnotations(userPrompt, info)
+		}
+		//best practices beyond schema validity, see lint.go
+		if *lint {
+			warnLintFindings(completion)
+		}
+		//referenced Secrets, ConfigMaps and the like which neither the manifest nor the cluster have, see references.go
+		if *checkReferences {
+			warnDanglingReferences(ctx, completion)
+		}
+		//with test-namespace the manifest is applied to a throwaway namespace first, a failure
+		//is reported but the user still decides whether to apply or reprompt
+		if *testNamespace {
+			if err := testInNamespace(ctx, completion, annotations); err != nil {
+				log.Errorf("the manifest failed in the test namespace: %v", err)
+			} else {
+				fmt.Printf("%sThe manifest applied cleanly in the test namespace\n", emoji("✅"))
+			}
+		}
+		//with server-dry-run the API server and its admission webhooks check the manifest, see dryrun.go.
+		//Denials are shown and a user at the terminal still decides whether to apply or reprompt, any other
+		//run stops, the apply would fail at the first denied object with the objects before it applied
+		if *serverDryRunFlag {
+			if err := serverDryRun(ctx, completion, annotations); err != nil {
+				if !*requireConfirmation || *approvalWebhook != "" || !promptTerminal() {
+					return fmt.Errorf("the server dry-run failed, not applying: %w", err)
+				}
+				log.Errorf("the server dry-run failed: %v", err)
+			}
 		}
 //the manifest created by open ai for kubernetes is in the completion variable, we're printing it now
 		// Print the manifest to be applied
-		text := fmt.Sprintf("✨ Attempting to apply the following manifest:\n%s", completion)
-		fmt.Println(text)
+		verb := "apply"
+		if *deleteMode {
+			verb = "delete"
+		}
+		//in plain progress mode the generated line above replaces the banner, see progress.go
+		if !plainProgress() {
+			text := fmt.Sprintf("%sAttempting to %s the following manifest:\n%s", emoji("✨"), verb, completion)
+			//with summary-only a line per object is enough for a quick yes or no, see manifest.go
+			if summary, err := manifestSummary(completion); *summaryOnly && err == nil {
+				text = fmt.Sprintf("%sAttempting to %s the following objects:\n%s", emoji("✨"), verb, summary)
+			}
+			fmt.Println(text)
+		}
+
+		//show what applying would change in the cluster, see diff.go
+		if *showDiff || *explainDiffFlag || *kubectlDiffFlag {
+			if err := printDiff(ctx, oaiClients, completion, annotations); err != nil {
+				log.Warnf("unable to diff the manifest: %v", err)
+			}
+		}
 
 		// Prompt user for action, action being apply or dontApply
-		//userActionPrompt is a function defined BELOW
-		action, err = userActionPrompt()
+		//userActionPrompt is a function defined BELOW, with approval-webhook a webhook decides, see approval.go
+		applyConfirmed = false
+		action, err = newApprovalProvider().approve(ctx, completion)
 		if err != nil {
 			return err
 		}
+		//a reprompt generates a new manifest, which gets its own correction round
+		schemaFixed = false
 
 		if action == dontApply {
-			return nil
+			return writeAuditLog(userPrompt, completion, info, auditDeclined)
 		}
 	}
 
@@ -185,13 +1105,85 @@ func run(args []string) error {
 	//right now we're outside the for loop for the 
 	//action being not equal to apply, meaning here the action is to apply the settings
 	//apply manifest is a function in kubernetes.go and this is why we call the function
-	return applyManifest(completion)
+	applyCtx, applySpan := startSpan(ctx, "apply",
+		attribute.Int("kubectl_assistant.objects", countDocuments(completion)),
+		attribute.Bool("kubectl_assistant.delete", *deleteMode),
+	)
+	err = applyManifest(applyCtx, completion, annotations)
+	switch {
+	case errors.Is(err, errAborted):
+		endSpan(applySpan, auditAborted, err)
+	case *deleteMode:
+		endSpan(applySpan, outcomeOf(err, auditDeleted), err)
+	default:
+		endSpan(applySpan, outcomeOf(err, auditApplied), err)
+	}
+	//the objects before the abort were applied, the audit log records that it was only partly
+	if errors.Is(err, errAborted) {
+		if auditErr := writeAuditLog(userPrompt, completion, info, auditAborted); auditErr != nil {
+			log.Warnf("unable to write the audit log: %v", auditErr)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if *deleteMode {
+		return writeAuditLog(userPrompt, completion, info, auditDeleted)
+	}
+	return writeAuditLog(userPrompt, completion, info, auditApplied)
+}
+
+// withPromptFile returns the args with the content of the prompt file in front of them.
+// If the prompt-file flag is not set, the args are returned as they are.
+func withPromptFile(args []string) ([]string, error) {
+	if *promptFile == "" {
+		return args, nil
+	}
+	content, err := os.ReadFile(*promptFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read prompt file: %w", err)
+	}
+	return append([]string{strings.TrimSpace(string(content)) + " "}, args...), nil
+}
+
+// spinnerCharset returns the frames of the processing spinner, the spinner-charset flag overrides the default
+// braille frames, which some terminals and locales can't render. The ascii flag uses plain ASCII frames.
+func spinnerCharset() []string {
+	switch {
+	case len(*spinnerFrames) > 0:
+		return *spinnerFrames
+	case *ascii:
+		return []string{"|", "/", "-", "\\"}
+	default:
+		return []string{"⣾", "⣽", "⣻", "⢿", "⡿", "⣟", "⣯", "⣷"}
+	}
+}
+
+// emoji returns the emoji followed by a space to prefix a message with, or nothing with the ascii flag.
+func emoji(e string) string {
+	if *ascii || plainProgress() {
+		return ""
+	}
+	return e + " "
+}
+
+// spinnerEnabled reports whether the processing spinner should be shown.
+// The spinner writes control characters which corrupt logs, so it is disabled for debug and raw
+// output, with the no-spinner flag and when stdout or stderr is not a terminal (e.g. in CI).
+func spinnerEnabled() bool {
+	if *debug || *raw || *noSpinner || *stream || plainProgress() {
+		return false
+	}
+	return term.IsTerminal(int(os.Stdout.Fd())) && term.IsTerminal(int(os.Stderr.Fd()))
 }
 
 // userActionPrompt prompts the user for an action and returns the selected action.
 // If requireConfirmation is not set, it immediately returns the "apply" action.
 // Otherwise, it presents a prompt to the user with options to apply or not apply.
 // The selected action is returned as a string.
+// Applying to a protected context always requires typing the context name, see confirmProtectedContext.
+// Without a terminal the answer is read from stdin as plain text, see plainActionPrompt.
 // If an error occurs during the prompt, it returns the "dontApply" action and the error.
 func userActionPrompt() (string, error) {
 //requireConfirmation is a flag we've defined on top of this file, basically ask permission
@@ -199,7 +1191,8 @@ func userActionPrompt() (string, error) {
 	if !*requireConfirmation {
 //if we have kept requireConfirmation as false, we can directly apply the manifest
 //we return 'apply' which is a string, as this function is supposed to return a string
-		return apply, nil
+		//protected contexts always need the typed confirmation
+		return confirmProtectedContext()
 	}
 //defining variables result to return from this function and err to handle errors
 	var result string
@@ -217,6 +1210,19 @@ func userActionPrompt() (string, error) {
 	if err == nil {
 		label = fmt.Sprintf("(context: %[1]s) %[2]s", currentContext, label)
 	}
+	//without a terminal promptui can't run, so the answer is read from stdin as plain text, see plainprompt.go
+	if !promptTerminal() {
+		plainLabel := "Would you like to apply this?"
+		if err == nil {
+			plainLabel = fmt.Sprintf("(context: %[1]s) %[2]s", currentContext, plainLabel)
+		}
+		result, err = plainActionPrompt(plainLabel)
+		if err != nil || result != apply {
+			return result, err
+		}
+		applyConfirmed = true
+		return confirmProtectedContext()
+	}
 //promptui is a package we have imported above, SelectWithAdd function
 //takes in the label we have just formatted above, items are apply and dontApply
 //reprompt is a const we have defined at the top
@@ -235,6 +1241,107 @@ func userActionPrompt() (string, error) {
 	if err != nil {
 		return dontApply, err
 	}
+	if result == apply {
+		applyConfirmed = true
+		return confirmProtectedContext()
+	}
 //returning the result from the prompt run
 	return result, nil
 }
+
+// applyConfirmed is set when the user confirmed the apply in userActionPrompt or typed the name of the protected
+// context, destructive steps like replacing objects with on-immutable only run then
+var applyConfirmed bool
+
+// protectedContext returns the name of the context we apply to and whether it matches one of the protected-contexts
+// patterns. With the kube-server flag there is no context, the server address is matched instead.
+func protectedContext() (string, bool) {
+	if len(*protectedContexts) == 0 {
+		return "", false
+	}
+	name := *kubeServer
+	if name == "" {
+		var err error
+		if name, err = getCurrentContextName(); err != nil || name == "" {
+			return "", false
+		}
+	}
+	for _, pattern := range *protectedContexts {
+		if matchWildcard(pattern, name) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// confirmProtectedContext asks the user to type the name of the context if it's protected, so a production cluster
+// isn't changed by accident. It returns apply if the context isn't protected or the name was typed correctly.
+func confirmProtectedContext() (string, error) {
+	name, ok := protectedContext()
+	if !ok {
+		return apply, nil
+	}
+	label := fmt.Sprintf("%sContext %s is protected, type the context name to proceed", emoji("⚠️"), name)
+	var input string
+	var err error
+	if promptTerminal() {
+		input, err = (&promptui.Prompt{Label: label}).Run()
+	} else {
+		input, err = readPlainAnswer(label)
+	}
+	if err != nil {
+		return dontApply, err
+	}
+	if strings.TrimSpace(input) != name {
+		fmt.Println("The context name doesn't match, not applying")
+		return dontApply, nil
+	}
+	applyConfirmed = true
+	return apply, nil
+}
+
+// objectActionPrompt asks the user whether to apply, skip or abort at a single object, ref
+// identifies the object like kubectl does, e.g. deployment.apps/nginx.
+// Without a terminal the answer is read from stdin as plain text, see plainObjectActionPrompt.
+// If an error occurs during the prompt, it returns the abortAll action and the error.
+func objectActionPrompt(ref string) (string, error) {
+	verb := apply
+	if *deleteMode {
+		verb = "Delete"
+	}
+	label := fmt.Sprintf("%s %s?", verb, ref)
+	if !promptTerminal() {
+		return plainObjectActionPrompt(label)
+	}
+	prompt := promptui.Select{
+		Label: label,
+		Items: []string{verb, skip, abortAll},
+	}
+	_, result, err := prompt.Run()
+	if err != nil {
+		return abortAll, err
+	}
+	if result == verb {
+		return apply, nil
+	}
+	return result, nil
+}
+
+// clarifyingQuestion checks whether the completion is a clarifying question rather than a manifest.
+// The model is asked to prefix questions with clarifyingQuestionPrefix, the question without the
+// prefix is returned together with true if that's the case.
+func clarifyingQuestion(completion string) (string, bool) {
+	trimmed := strings.TrimSpace(completion)
+	if !strings.HasPrefix(trimmed, clarifyingQuestionPrefix) {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(trimmed, clarifyingQuestionPrefix)), true
+}
+
+// clarifyingQuestionPrompt shows the model's question to the user and returns the typed answer.
+func clarifyingQuestionPrompt(question string) (string, error) {
+	prompt := promptui.Prompt{
+		Label: fmt.Sprintf("%s%s", emoji("🤔"), question),
+	}
+	return prompt.Run()
+}
@@ -2,10 +2,13 @@ package cli
 //COMPLETE
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"os/exec"
 	"strings"
 
@@ -14,19 +17,34 @@ import (
 
 //this func. is being called in both fetchResourceName and fetchSchemaForResource functions below
 // fetchK8sSchema fetches the Kubernetes schema either from the Kubernetes API server or from a specified URL.
-// It returns the schema as a map[string]interface{} and an error if any.
+// It returns the schema as a map[string]interface{} and an error if any. The schema is only downloaded once
+// per conversation, see schemafetch.go, so the returned map must not be modified.
 func fetchK8sSchema() (map[string]interface{}, error) {
+	schema, err := schemas.lookup(fullSchemaKey, func() (interface{}, error) { return downloadK8sSchema() })
+	if err != nil {
+		return nil, err
+	}
+	return schema.(map[string]interface{}), nil
+}
+
+// downloadK8sSchema downloads and parses the schema for fetchK8sSchema.
+func downloadK8sSchema() (map[string]interface{}, error) {
 	var body []byte
 	var err error
-//if the APIURL for k8s hasnt' been specified, we use exec package to create a command with kubectl
-//this is done in the runKubectlCommand function that's called from here
+//if the APIURL for k8s hasnt' been specified, we fetch it from the API server with kubectl,
+//or with the REST client if kubectl isn't installed, this is done in the getRaw function
 	if *k8sOpenAPIURL == "" {
 		log.Debugf("Fetching schema from Kubernetes API server")
-//getKubeConfig function is defined in kubernetes.go file 
-		kubeConfig := getKubeConfig()
-//runKubectlCommand is defined below in this file, call it and get the response
+//getRaw is defined below in this file, call it and get the response
 //in the body variable
-		body, err = runKubectlCommand("get", "--raw", "/openapi/v2", "--kubeconfig", kubeConfig)
+		body, err = getRaw("/openapi/v2")
+		if err != nil {
+			return nil, err
+		}
+	} else if path, ok := schemaFile(*k8sOpenAPIURL); ok {
+		//a schema saved to a file works without the cluster and without network access
+		log.Debugf("Reading schema from %s", path)
+		body, err = os.ReadFile(path)
 		if err != nil {
 			return nil, err
 		}
@@ -50,10 +68,25 @@ func fetchK8sSchema() (map[string]interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	//custom resources of installed operators are validated and looked up like built-in resources, see crd.go
+	if definitions, ok := schema["definitions"].(map[string]interface{}); ok {
+		addCRDDefinitions(definitions)
+	}
 //this function returns the map schema
 	return schema, nil
 }
 
+// schemaFile returns the path of the schema file if the k8s-openapi-url is a local file, a file:// URL or a path.
+func schemaFile(openAPIURL string) (string, bool) {
+	if path, ok := strings.CutPrefix(openAPIURL, "file://"); ok {
+		return path, true
+	}
+	if strings.HasPrefix(openAPIURL, "http://") || strings.HasPrefix(openAPIURL, "https://") {
+		return "", false
+	}
+	return openAPIURL, true
+}
+
 // fetchResourceNames fetches the resource names that match the given resourceName.
 // It retrieves the Kubernetes schema and searches for resource names in the schema definitions.
 // The resourceName parameter is case-insensitive.
@@ -89,6 +122,16 @@ func fetchResourceNames(resourceName string) ([]string, error) {
 // fetchSchemaForResource fetches the schema for a given resource type.
 // It returns the resource schema as a map[string]interface{} and an error if any.
 func fetchSchemaForResource(resourceType string) (map[string]interface{}, error) {
+	//with targeted-schema we first try the much smaller per-group OpenAPI v3 document,
+	//and only fall back to downloading the full /openapi/v2 schema if that doesn't work out
+	if *targetedSchema && *k8sOpenAPIURL == "" {
+		rs, err := fetchGroupSchemaForResource(resourceType)
+		if err == nil {
+			return rs, nil
+		}
+		log.Debugf("targeted schema fetch for %s failed, falling back to full schema: %v", resourceType, err)
+	}
+
 	// Fetch the Kubernetes schema
 	schema, err := fetchK8sSchema()
 	if err != nil {
@@ -117,9 +160,172 @@ func fetchSchemaForResource(resourceType string) (map[string]interface{}, error)
 	return nil, errors.New("unable to find resource schema")
 }
 
+// openAPIV3Index is the document served at /openapi/v3, it lists one entry per API group/version
+// e.g. "apis/apps/v1" together with the URL (including a content hash) to fetch it from
+type openAPIV3Index struct {
+	Paths map[string]struct {
+		ServerRelativeURL string `json:"serverRelativeURL"`
+	} `json:"paths"`
+}
+
+// fetchGroupSchemaForResource fetches the schema for a given resource type from the OpenAPI v3
+// document of its API group only, e.g. /openapi/v3/apis/apps/v1 for io.k8s.api.apps.v1.Deployment.
+// This avoids downloading and parsing the complete /openapi/v2 schema on large clusters.
+func fetchGroupSchemaForResource(resourceType string) (map[string]interface{}, error) {
+	url, err := openAPIV3PathForResource(resourceType)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("Fetching schema from Kubernetes API server path %s", url)
+	body, err := getRawCached(url)
+	if err != nil {
+		return nil, err
+	}
+
+	//in OpenAPI v3 the definitions live in components.schemas instead of definitions
+	var doc struct {
+		Components struct {
+			Schemas map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+
+	resourceSchema, ok := doc.Components.Schemas[resourceType]
+	if !ok {
+		return nil, errors.New("unable to find resource schema")
+	}
+	rs, ok := resourceSchema.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("unable to assert resource schema")
+	}
+	return rs, nil
+}
+
+// openAPIV3PathForResource returns the server relative URL of the OpenAPI v3 document of the API group
+// a resource type belongs to, from the /openapi/v3 index.
+func openAPIV3PathForResource(resourceType string) (string, error) {
+	group, version, err := groupVersionForResource(resourceType)
+	if err != nil {
+		return "", err
+	}
+
+	body, err := getRawCached("/openapi/v3")
+	if err != nil {
+		return "", err
+	}
+
+	var index openAPIV3Index
+	if err := json.Unmarshal(body, &index); err != nil {
+		return "", err
+	}
+
+	for p, entry := range index.Paths {
+		if openAPIV3PathMatches(p, group, version) {
+			return entry.ServerRelativeURL, nil
+		}
+	}
+	return "", fmt.Errorf("unable to find OpenAPI v3 path for %s", resourceType)
+}
+
+// k8sGroupNames are the full names of the built-in API groups whose name in the resource types is only the
+// first part of the group, e.g. rbac for rbac.authorization.k8s.io. Groups like apps and batch are named as they are.
+var k8sGroupNames = map[string]string{
+	"admissionregistration": "admissionregistration.k8s.io",
+	"apiextensions":         "apiextensions.k8s.io",
+	"apiregistration":       "apiregistration.k8s.io",
+	"authentication":        "authentication.k8s.io",
+	"authorization":         "authorization.k8s.io",
+	"certificates":          "certificates.k8s.io",
+	"coordination":          "coordination.k8s.io",
+	"discovery":             "discovery.k8s.io",
+	"events":                "events.k8s.io",
+	"flowcontrol":           "flowcontrol.apiserver.k8s.io",
+	"internal":              "internal.apiserver.k8s.io",
+	"networking":            "networking.k8s.io",
+	"node":                  "node.k8s.io",
+	"rbac":                  "rbac.authorization.k8s.io",
+	"resource":              "resource.k8s.io",
+	"scheduling":            "scheduling.k8s.io",
+	"storage":               "storage.k8s.io",
+	"storagemigration":      "storagemigration.k8s.io",
+}
+
+// groupVersionForResource derives the full API group and version a fully-namespaced resource type
+// belongs to, e.g. io.k8s.api.apps.v1.Deployment returns apps and v1, io.k8s.api.rbac.v1.Role returns
+// rbac.authorization.k8s.io and v1. Other resource types are reverse domain names of their group, e.g.
+// com.example.stable.v1.CronTab returns stable.example.com and v1.
+func groupVersionForResource(resourceType string) (string, string, error) {
+	parts := strings.Split(resourceType, ".")
+	//we need at least group, version and kind
+	if len(parts) < 3 {
+		return "", "", fmt.Errorf("unable to determine API group of %s", resourceType)
+	}
+	version := parts[len(parts)-2]
+	if strings.HasPrefix(resourceType, "io.k8s.") {
+		group := parts[len(parts)-3]
+		if full, ok := k8sGroupNames[group]; ok {
+			group = full
+		}
+		return group, version, nil
+	}
+	domain := parts[:len(parts)-2]
+	group := make([]string, 0, len(domain))
+	for i := len(domain) - 1; i >= 0; i-- {
+		group = append(group, domain[i])
+	}
+	return strings.Join(group, "."), version, nil
+}
+
+// openAPIV3PathMatches reports whether an OpenAPI v3 index path serves the given group and version.
+// The core group lives under api/v1, all other groups under apis/<group>/<version> with the full group name.
+func openAPIV3PathMatches(path, group, version string) bool {
+	if group == "core" {
+		return path == "api/"+version
+	}
+	return path == "apis/"+group+"/"+version
+}
+
+// getRaw gets the raw response of an API server path like kubectl get --raw does. kubectl is used if it
+// is installed, otherwise the request is made with the REST client of the configuration in kubernetes.go,
+// so the schema tools also work where only the kubeconfig or the in-cluster config is available.
+func getRaw(path string) ([]byte, error) {
+	//kubectl doesn't know about the kube-server flag, the REST client does
+	if checkKubectl() == nil && *kubeServer == "" {
+		return runKubectlCommand("get", "--raw", path, "--kubeconfig", getKubeConfig())
+	}
+	log.Debugf("fetching %s with the REST client", path)
+	c, err := getClientset()
+	if err != nil {
+		return nil, err
+	}
+	return c.Discovery().RESTClient().Get().AbsPath(path).DoRaw(context.Background())
+}
+
+// checkKubectl returns an actionable error if kubectl is not installed, instead of the
+// confusing "executable file not found" error exec would return.
+func checkKubectl() error {
+	if _, err := exec.LookPath("kubectl"); err != nil {
+		return errors.New("kubectl was not found in PATH, install kubectl to use this feature")
+	}
+	return nil
+}
+
 // runKubectlCommand executes a kubectl command with the provided arguments and returns the output as a byte slice.
 //function is being called in the fetchk8sSchema function above
 func runKubectlCommand(args ...string) ([]byte, error) {
+	return runKubectlCommandWithInput(nil, args...)
+}
+
+// runKubectlCommandWithInput is runKubectlCommand with the stdin of kubectl read from stdin, e.g. for -f -.
+// A nil stdin runs kubectl without any input.
+func runKubectlCommandWithInput(stdin io.Reader, args ...string) ([]byte, error) {
+	if err := checkKubectl(); err != nil {
+		return nil, err
+	}
+
 	// Create a new exec.Command with "kubectl" as the command and the provided arguments.
 	//formulate a command for kubernetes, the command will be kubectl ls or something
 	
@@ -127,18 +333,26 @@ func runKubectlCommand(args ...string) ([]byte, error) {
 	//and run it as a command and this is done with the exec package that enables us
 	//to create our own commands and run them
 	cmd := exec.Command("kubectl", args...)
+	cmd.Stdin = stdin
 
 	// Create a buffer to store the command output.
 	//a variable out has been defined as bytes.Buffer or temporary storage
 	var out bytes.Buffer
 	//we assign the std output of the command as out (which is bytes.Buffer)
 	cmd.Stdout = &out
+	//the error messages of kubectl are more helpful than just its exit code
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
 
 	// Run the command and wait for it to complete.
 	//we run the command we had formulated above
 	err := cmd.Run()
 	if err != nil {
-		return nil, err
+		//some commands like kubectl diff write their output and exit with an error, so the output is returned too
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return out.Bytes(), err
 	}
 
 	// Return the command output as a byte slice.
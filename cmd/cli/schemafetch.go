@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxConcurrentSchemaFetches limits the schema documents fetched from the API server at the same time
+const maxConcurrentSchemaFetches = 4
+
+// fullSchemaKey is the key of the parsed /openapi/v2 schema in the schemaFetcher
+const fullSchemaKey = "schema"
+
+// schemaLookup is a fetch of the schemaFetcher, done is closed once value and err are set
+type schemaLookup struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// schemaFetcher shares the schemas fetched during a conversation between the function calls of the model.
+// Concurrent lookups of the same key wait for a single fetch and later lookups get the cached result,
+// failed fetches aren't cached so the next lookup tries again.
+type schemaFetcher struct {
+	mu      sync.Mutex
+	lookups map[string]*schemaLookup
+	//limits the fetches running at the same time
+	slots chan struct{}
+}
+
+// newSchemaFetcher returns an empty schemaFetcher.
+func newSchemaFetcher() *schemaFetcher {
+	return &schemaFetcher{
+		lookups: map[string]*schemaLookup{},
+		slots:   make(chan struct{}, maxConcurrentSchemaFetches),
+	}
+}
+
+// schemas is the schemaFetcher of the current generation, it is reset by run and prefetchBatchCompletions
+var schemas = newSchemaFetcher()
+
+// reset drops the cached schemas, e.g. at the start of a conversation so CRDs installed since are found.
+func (f *schemaFetcher) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lookups = map[string]*schemaLookup{}
+}
+
+// lookup returns the cached value of key, or fetches it with fetch.
+func (f *schemaFetcher) lookup(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	f.mu.Lock()
+	if l, ok := f.lookups[key]; ok {
+		f.mu.Unlock()
+		<-l.done
+		return l.value, l.err
+	}
+	l := &schemaLookup{done: make(chan struct{})}
+	f.lookups[key] = l
+	f.mu.Unlock()
+
+	f.slots <- struct{}{}
+	l.value, l.err = fetch()
+	<-f.slots
+
+	if l.err != nil {
+		f.mu.Lock()
+		//the fetcher may have been reset in the meantime
+		if f.lookups[key] == l {
+			delete(f.lookups, key)
+		}
+		f.mu.Unlock()
+	}
+	close(l.done)
+	return l.value, l.err
+}
+
+// prefetch starts fetching the keys in the background, so they are cached by the time the model asks for them.
+func (f *schemaFetcher) prefetch(keys []string, fetch func(key string) (interface{}, error)) {
+	for _, key := range keys {
+		key := key
+		go func() {
+			if _, err := f.lookup(key, func() (interface{}, error) { return fetch(key) }); err != nil {
+				log.Debugf("unable to prefetch %s: %v", key, err)
+			}
+		}()
+	}
+}
+
+// getRawCached is getRaw through the schemaFetcher of the conversation.
+func getRawCached(path string) ([]byte, error) {
+	body, err := schemas.lookup(path, func() (interface{}, error) { return getRaw(path) })
+	if err != nil {
+		return nil, err
+	}
+	return body.([]byte), nil
+}
+
+// prefetchSchemas starts fetching the schema the first function call of the model needs, while the model is
+// still working on the prompt.
+func prefetchSchemas() {
+	schemas.prefetch([]string{fullSchemaKey}, func(string) (interface{}, error) { return downloadK8sSchema() })
+}
+
+// prefetchGroupSchemas starts fetching the OpenAPI v3 documents of the API groups of the resource names
+// findSchemaNames returned, the model usually asks for the schemas of some of them next.
+func prefetchGroupSchemas(resourceNames []string) {
+	if !*targetedSchema || *k8sOpenAPIURL != "" {
+		return
+	}
+	go func() {
+		var paths []string
+		seen := map[string]bool{}
+		for _, name := range resourceNames {
+			//the index is fetched once and then cached
+			path, err := openAPIV3PathForResource(name)
+			if err != nil || seen[path] {
+				continue
+			}
+			seen[path] = true
+			paths = append(paths, path)
+		}
+		schemas.prefetch(paths, func(path string) (interface{}, error) { return getRaw(path) })
+	}()
+}
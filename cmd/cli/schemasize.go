@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"encoding/json"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// summaryKeys are the keys of a schema that are kept when summarizing it, enough for the model to
+// know which fields exist, their types and where to look them up
+var summaryKeys = map[string]bool{
+	"type":                            true,
+	"format":                          true,
+	"$ref":                            true,
+	"allOf":                           true,
+	"items":                           true,
+	"additionalProperties":            true,
+	"properties":                      true,
+	"required":                        true,
+	"enum":                            true,
+	"x-kubernetes-group-version-kind": true,
+	"x-kubernetes-int-or-string":      true,
+}
+
+// truncatedPropertiesKey is the number of properties dropped from a schema that still didn't fit
+const truncatedPropertiesKey = "x-truncated-properties"
+
+// capSchema marshals the schema of a resource, trimming it step by step until its estimated size is at most
+// maxTokens: descriptions are dropped first, then everything but the field names, types and references, and
+// finally properties, keeping the required ones. A maxTokens of 0 returns the complete schema.
+func capSchema(schema map[string]interface{}, maxTokens int) ([]byte, error) {
+	full, err := json.Marshal(schema)
+	if err != nil || maxTokens <= 0 || estimateTokens(string(full)) <= maxTokens {
+		return full, err
+	}
+
+	summarized := summarizeSchema(schema, false).(map[string]interface{})
+	b, err := json.Marshal(summarized)
+	if err != nil || estimateTokens(string(b)) <= maxTokens {
+		log.Debugf("schema of %d estimated tokens summarized without descriptions", estimateTokens(string(full)))
+		return b, err
+	}
+
+	summarized = summarizeSchema(schema, true).(map[string]interface{})
+	b, err = json.Marshal(summarized)
+	if err != nil || estimateTokens(string(b)) <= maxTokens {
+		log.Debugf("schema of %d estimated tokens summarized to field names and types", estimateTokens(string(full)))
+		return b, err
+	}
+
+	log.Debugf("schema of %d estimated tokens truncated to %d", estimateTokens(string(full)), maxTokens)
+	return truncateProperties(summarized, maxTokens)
+}
+
+// summarizeSchema returns a copy of the schema without descriptions, with keysOnly only the summaryKeys are kept.
+// The names of properties are never dropped, only their schemas are summarized.
+func summarizeSchema(value interface{}, keysOnly bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		summarized := map[string]interface{}{}
+		for k, child := range v {
+			switch {
+			case k == "description":
+				continue
+			case k == "properties":
+				props, ok := child.(map[string]interface{})
+				if !ok {
+					break
+				}
+				summarizedProps := map[string]interface{}{}
+				for name, prop := range props {
+					summarizedProps[name] = summarizeSchema(prop, keysOnly)
+				}
+				summarized[k] = summarizedProps
+				continue
+			case keysOnly && !summaryKeys[k]:
+				continue
+			}
+			summarized[k] = summarizeSchema(child, keysOnly)
+		}
+		return summarized
+	case []interface{}:
+		summarized := make([]interface{}, len(v))
+		for i, child := range v {
+			summarized[i] = summarizeSchema(child, keysOnly)
+		}
+		return summarized
+	}
+	return value
+}
+
+// truncateProperties drops properties of the schema until it fits into maxTokens, required properties are
+// kept as long as possible and the others are dropped in reverse alphabetical order. The number of dropped
+// properties is recorded so the model knows the schema is incomplete.
+func truncateProperties(schema map[string]interface{}, maxTokens int) ([]byte, error) {
+	props, _ := schema["properties"].(map[string]interface{})
+	required := map[string]bool{}
+	if r, ok := schema["required"].([]interface{}); ok {
+		for _, name := range r {
+			if s, ok := name.(string); ok {
+				required[s] = true
+			}
+		}
+	}
+
+	//the last names are dropped first
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if required[names[i]] != required[names[j]] {
+			return required[names[i]]
+		}
+		return names[i] < names[j]
+	})
+
+	dropped := 0
+	for {
+		b, err := json.Marshal(schema)
+		if err != nil || estimateTokens(string(b)) <= maxTokens || len(names) == 0 {
+			return b, err
+		}
+		name := names[len(names)-1]
+		names = names[:len(names)-1]
+		delete(props, name)
+		dropped++
+		schema[truncatedPropertiesKey] = dropped
+	}
+}
@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	openai "github.com/sashabaranov/go-openai"
+	log "github.com/sirupsen/logrus"
+)
+
+// streamOutput is where the stream flag writes the manifest while the model generates it, stdout is
+// kept for the manifest and the prompts
+var streamOutput io.Writer = os.Stderr
+
+// streamChatCompletion sends the chat request with streaming and assembles the deltas into a response like
+// CreateChatCompletion returns it. The content is written to streamOutput as it arrives, the function call
+// arrives in parts too: its name first and then the arguments piece by piece. Models answering with tool calls
+// instead are handled the same way, only the first call is used as the loop in openaiGptChatCompletion makes
+// one call per turn.
+func (c *oaiClients) streamChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	stream, err := c.openAIClient.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	defer stream.Close()
+
+	var (
+		model        string
+		content      []byte
+		functionCall *openai.FunctionCall
+		finishReason openai.FinishReason
+		//tool calls are streamed by their index, the deltas of parallel calls can be interleaved
+		toolCalls = map[int]*openai.ToolCall{}
+	)
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		if chunk.Model != "" {
+			model = chunk.Model
+		}
+		//Azure sends a first chunk without choices with the results of the content filter
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		choice := chunk.Choices[0]
+		if choice.FinishReason != "" {
+			finishReason = choice.FinishReason
+		}
+		delta := choice.Delta
+		if delta.Content != "" {
+			content = append(content, delta.Content...)
+			fmt.Fprint(streamOutput, delta.Content)
+		}
+		if delta.FunctionCall != nil {
+			if functionCall == nil {
+				functionCall = &openai.FunctionCall{}
+			}
+			functionCall.Name += delta.FunctionCall.Name
+			functionCall.Arguments += delta.FunctionCall.Arguments
+		}
+		for _, tc := range delta.ToolCalls {
+			index := 0
+			if tc.Index != nil {
+				index = *tc.Index
+			}
+			call, ok := toolCalls[index]
+			if !ok {
+				call = &openai.ToolCall{Index: &index, Type: openai.ToolTypeFunction}
+				toolCalls[index] = call
+			}
+			if tc.ID != "" {
+				call.ID = tc.ID
+			}
+			call.Function.Name += tc.Function.Name
+			call.Function.Arguments += tc.Function.Arguments
+		}
+	}
+	if len(content) > 0 {
+		fmt.Fprintln(streamOutput)
+	}
+
+	message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: string(content), FunctionCall: functionCall}
+	if len(toolCalls) > 0 {
+		indexes := make([]int, 0, len(toolCalls))
+		for index := range toolCalls {
+			indexes = append(indexes, index)
+		}
+		sort.Ints(indexes)
+		for _, index := range indexes {
+			message.ToolCalls = append(message.ToolCalls, *toolCalls[index])
+		}
+		if message.FunctionCall == nil {
+			if len(message.ToolCalls) > 1 {
+				log.Debugf("the model made %d tool calls, only calling %s", len(message.ToolCalls), message.ToolCalls[0].Function.Name)
+			}
+			message.FunctionCall = &message.ToolCalls[0].Function
+		}
+	}
+	log.Debugf("streamed %d bytes, finish reason: %s", len(content), finishReason)
+
+	return openai.ChatCompletionResponse{
+		Model:   model,
+		Choices: []openai.ChatCompletionChoice{{Message: message, FinishReason: finishReason}},
+	}, nil
+}
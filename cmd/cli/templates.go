@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// templateExtension is the extension of the files in the template directory, the name of a template is the file name without it
+const templateExtension = ".yaml"
+
+// templateInstructions asks the model to keep the structure of the skeleton, so every generation of a template looks the same
+const templateInstructions = "Generate the manifest by filling in the following template from the request. Keep every document, kind, field and label of the template in the same order, replace the placeholders with values from the request and only add what the request asks for. Template:\n"
+
+// manifestSkeleton is the manifest of the template flag, it's read once and used for every generation of the run
+var manifestSkeleton string
+
+// defaultTemplateDir returns the default template directory in the user's config directory.
+func defaultTemplateDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "kubectl-assistant", "templates")
+}
+
+// loadTemplate reads the template of the template flag from the template directory. A missing template
+// returns an error listing the templates there are.
+func loadTemplate() error {
+	if *templateName == "" {
+		return nil
+	}
+	path := filepath.Join(*templateDir, *templateName+templateExtension)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		names := templateNames()
+		if len(names) == 0 {
+			return fmt.Errorf("template %s not found, %s has no templates", *templateName, *templateDir)
+		}
+		return fmt.Errorf("template %s not found in %s, available templates: %s", *templateName, *templateDir, strings.Join(names, ", "))
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read template %s: %w", *templateName, err)
+	}
+	manifestSkeleton = strings.TrimSpace(string(data))
+	if manifestSkeleton == "" {
+		return fmt.Errorf("template %s is empty", path)
+	}
+	log.Debugf("using template %s", path)
+	return nil
+}
+
+// templateNames returns the names of the templates in the template directory, sorted.
+func templateNames() []string {
+	matches, err := filepath.Glob(filepath.Join(*templateDir, "*"+templateExtension))
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(matches))
+	for _, m := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(m), templateExtension))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// templatePrompt returns the instructions for the template, the template is delimited so the model can tell it from the request.
+func templatePrompt() string {
+	return fmt.Sprintf("%s---\n%s\n---\n", templateInstructions, manifestSkeleton)
+}
+
+// checkTemplateKinds warns about the kinds of the template the generated manifest doesn't have, e.g. the model
+// left out the Service of a web service. Templates which aren't valid YAML because of their placeholders are
+// not checked.
+func checkTemplateKinds(completion string) {
+	expected, err := decodeManifest(manifestSkeleton)
+	if err != nil {
+		log.Debugf("unable to check the manifest against the template: %v", err)
+		return
+	}
+	objects, err := decodeManifest(completion)
+	if err != nil {
+		return
+	}
+	generated := map[string]int{}
+	for _, obj := range objects {
+		generated[obj.GetKind()]++
+	}
+	for _, obj := range expected {
+		if generated[obj.GetKind()] == 0 {
+			log.Warnf("the manifest is missing a %s of the template %s", obj.GetKind(), *templateName)
+			continue
+		}
+		generated[obj.GetKind()]--
+	}
+}
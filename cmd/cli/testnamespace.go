@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// testNamespacePrefix is the prefix of the generated name of the throwaway namespace
+const testNamespacePrefix = "kubectl-assistant-test-"
+
+// testInNamespace applies the manifest to a temporary namespace and deletes the namespace again, so
+// the manifest is validated by the API server and admission before the real namespace is touched.
+// Namespaced objects are moved to the temporary namespace, cluster-scoped objects are skipped since
+// applying them would change the cluster. It returns the first error the API server reported.
+func testInNamespace(ctx context.Context, completion string, annotations map[string]string) error {
+	config, err := getRestConfig(getKubeConfig())
+	if err != nil {
+		return err
+	}
+	c, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	dd, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	//the test sees the same objects as the real apply, they are moved to the test namespace below
+	objects, _, err := prepareObjects(ctx, completion, annotations)
+	if err != nil {
+		return err
+	}
+
+	ns, err := c.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: testNamespacePrefix},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create test namespace: %w", err)
+	}
+	//the namespace is deleted even if the user pressed Ctrl-C, so we don't use the canceled ctx
+	defer func() {
+		deleteCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := c.CoreV1().Namespaces().Delete(deleteCtx, ns.Name, metav1.DeleteOptions{}); err != nil {
+			log.Warnf("unable to delete test namespace %s: %v", ns.Name, err)
+		}
+	}()
+	fmt.Printf("%sTesting the manifest in namespace %s\n", emoji("🧪"), ns.Name)
+
+	for _, obj := range objects {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		mapping, err := restMapping(c, obj.GroupVersionKind())
+		if err != nil {
+			return err
+		}
+		if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+			log.Warnf("skipping cluster-scoped %s in the test namespace", objectRef(mapping, obj))
+			continue
+		}
+		//the object is a copy, the manifest applied to the real namespace later stays as it is
+		obj.SetNamespace(ns.Name)
+		//the namespace is new, so nothing exists in it yet
+		if _, err := applyObject(ctx, dd.Resource(mapping.Resource).Namespace(ns.Name), obj, nil); err != nil {
+			return fmt.Errorf("%s failed in the test namespace: %w", objectRef(mapping, obj), err)
+		}
+		fmt.Printf("%s ok\n", objectRef(mapping, obj))
+	}
+	return nil
+}
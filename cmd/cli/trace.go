@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// traceIDField is the logrus field of the trace id in every log line
+const traceIDField = "trace_id"
+
+// traceID correlates the log lines, audit log entries and structured output of a run, see startTrace
+var traceID string
+
+// traceHook adds the trace id of the current run to every log entry, it is added in InitAndExecute
+type traceHook struct{}
+
+func (traceHook) Levels() []log.Level { return log.AllLevels }
+
+func (traceHook) Fire(entry *log.Entry) error {
+	if traceID != "" {
+		entry.Data[traceIDField] = traceID
+	}
+	return nil
+}
+
+// startTrace sets the trace id of a run, the trace-id flag or a new random id.
+func startTrace() {
+	if *traceIDFlag != "" {
+		traceID = *traceIDFlag
+		return
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Debugf("unable to generate a trace id: %v", err)
+		traceID = ""
+		return
+	}
+	traceID = hex.EncodeToString(b)
+}
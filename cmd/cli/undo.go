@@ -0,0 +1,208 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// undoEntry is an object changed by the last apply, previous is the object before the apply
+// or nil if the apply created it
+type undoEntry struct {
+	APIVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Namespace  string                 `json:"namespace,omitempty"`
+	Name       string                 `json:"name"`
+	Previous   map[string]interface{} `json:"previous,omitempty"`
+}
+
+// undoState records the objects changed by the last apply, so the undo command can revert it
+type undoState struct {
+	Time    time.Time   `json:"time"`
+	Entries []undoEntry `json:"entries"`
+}
+
+// newUndoState returns the state for an apply, a resumed apply extends the state of the interrupted one.
+func newUndoState() *undoState {
+	if *resume {
+		if state, err := loadUndoState(); err == nil && state != nil {
+			return state
+		}
+	}
+	return &undoState{Time: time.Now()}
+}
+
+// undoStateFile returns the path of the undo state file in the user's config directory.
+func undoStateFile() (string, error) {
+	return stateFile("undo.json")
+}
+
+// loadUndoState returns the state of the last apply, or nil if there is nothing to undo.
+func loadUndoState() (*undoState, error) {
+	path, err := undoStateFile()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state undoState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("unable to parse undo state %s: %w", path, err)
+	}
+	return &state, nil
+}
+
+// record adds the object which is about to be changed, live is the object before the change or nil.
+func (s *undoState) record(obj, live *unstructured.Unstructured) {
+	entry := undoEntry{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+	}
+	if live != nil {
+		entry.Previous = live.Object
+	}
+	s.Entries = append(s.Entries, entry)
+}
+
+// save writes the state, it's called before every change so even a partial apply can be undone.
+func (s *undoState) save() error {
+	path, err := undoStateFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	//previous objects may be secrets, so only the user can read them
+	return os.WriteFile(path, data, 0o600)
+}
+
+// undoCmd returns the undo subcommand, which reverts the last apply.
+func undoCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "undo",
+		Short: "Revert the last apply",
+		Long:  "Revert the last apply, objects it changed are restored to their previous version and objects it created are deleted.",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer cancel()
+			return undoLastApply(ctx)
+		},
+	}
+}
+
+// undoLastApply restores the objects changed by the last apply in reverse order, objects which
+// didn't exist before are deleted. The state is removed once everything was reverted.
+func undoLastApply(ctx context.Context) error {
+	state, err := loadUndoState()
+	if err != nil {
+		return err
+	}
+	if state == nil || len(state.Entries) == 0 {
+		return errors.New("there is no apply to undo")
+	}
+
+	var plan strings.Builder
+	for _, e := range state.Entries {
+		action := "restore"
+		if e.Previous == nil {
+			action = "delete"
+		}
+		fmt.Fprintf(&plan, "  %s %s/%s\n", action, e.Kind, e.Name)
+	}
+	fmt.Printf("%sUndoing the apply of %s:\n%s", emoji("↩️"), state.Time.Format(time.RFC1123), plan.String())
+	//an undo deletes and restores objects, so it's confirmed like an apply, see approval.go
+	ok, err := newApprovalProvider().approveUndo(ctx, plan.String())
+	if err != nil || !ok {
+		return err
+	}
+
+	config, err := getRestConfig(getKubeConfig())
+	if err != nil {
+		return err
+	}
+	c, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	dd, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	for i := len(state.Entries) - 1; i >= 0; i-- {
+		e := state.Entries[i]
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(e.APIVersion)
+		obj.SetKind(e.Kind)
+		mapping, err := restMapping(c, obj.GroupVersionKind())
+		if err != nil {
+			return err
+		}
+		var dri dynamic.ResourceInterface = dd.Resource(mapping.Resource)
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			dri = dd.Resource(mapping.Resource).Namespace(e.Namespace)
+		}
+		obj.SetName(e.Name)
+		ref := objectRef(mapping, obj)
+
+		if e.Previous == nil {
+			err := dri.Delete(ctx, e.Name, metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("unable to delete %s: %w", ref, err)
+			}
+			fmt.Printf("%s %s\n", ref, resultDeleted)
+			continue
+		}
+
+		previous := restorableObject(e.Previous)
+		//force takes over the fields other managers changed since, the previous version wins
+		if _, err := dri.Apply(ctx, e.Name, previous, metav1.ApplyOptions{FieldManager: "application/apply-patch", Force: true}); err != nil {
+			return fmt.Errorf("unable to restore %s: %w", ref, err)
+		}
+		fmt.Printf("%s restored\n", ref)
+	}
+
+	path, err := undoStateFile()
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// restorableObject returns the previous object without the fields the server sets,
+// which can't be part of an apply.
+func restorableObject(previous map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: previous}
+	for _, field := range []string{"resourceVersion", "uid", "creationTimestamp", "managedFields", "generation", "selfLink"} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	unstructured.RemoveNestedField(obj.Object, "status")
+	return obj
+}
@@ -0,0 +1,27 @@
+package cli
+
+import (
+	"net/http"
+)
+
+// userAgent returns the User-Agent requests to OpenAI and the cluster identify with,
+// kubectl-assistant/<version> unless the user-agent flag overrides it.
+func userAgent() string {
+	if *userAgentFlag != "" {
+		return *userAgentFlag
+	}
+	return "kubectl-assistant/" + version
+}
+
+// userAgentTransport sets the User-Agent header on every request before passing it on to next
+type userAgentTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	//a RoundTripper must not modify the request it was given
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", userAgent())
+	return t.next.RoundTrip(req)
+}
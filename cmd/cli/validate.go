@@ -0,0 +1,213 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	runtimeschema "k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// validateManifestSchema validates every document of the manifest against the Kubernetes OpenAPI schema.
+// It returns a list of problems like "Deployment/nginx: spec.replica: unknown field", which is empty
+// for a valid manifest. Objects without a schema, e.g. custom resources missing from the spec, are skipped.
+func validateManifestSchema(completion string) ([]string, error) {
+	objects, err := decodeManifest(completion)
+	if err != nil {
+		return nil, err
+	}
+	definitions, err := schemaDefinitions()
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for _, obj := range objects {
+		objProblems, found := validateObject(definitions, obj)
+		if !found {
+			log.Debugf("no schema found for %s, skipping validation", obj.GroupVersionKind())
+			continue
+		}
+		for _, problem := range objProblems {
+			problems = append(problems, fmt.Sprintf("%s/%s: %s", obj.GetKind(), obj.GetName(), problem))
+		}
+	}
+	return problems, nil
+}
+
+// schemaDefinitions returns the definitions of the Kubernetes OpenAPI schema.
+func schemaDefinitions() (map[string]interface{}, error) {
+	//fetchK8sSchema is in schema.go, it uses the cluster or the k8s-openapi-url
+	schema, err := fetchK8sSchema()
+	if err != nil {
+		return nil, err
+	}
+	definitions, ok := schema["definitions"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("unable to assert schema definitions")
+	}
+	return definitions, nil
+}
+
+// validateObject validates a single object against its schema definition and returns the problems with the
+// path to the field, e.g. "spec.replica: unknown field". found is false if the schema has no definition for it.
+func validateObject(definitions map[string]interface{}, obj *unstructured.Unstructured) (problems []string, found bool) {
+	def := definitionForGVK(definitions, obj.GroupVersionKind())
+	if def == nil {
+		return nil, false
+	}
+	//map iteration order is random, sort so the same manifest always gives the same report
+	problems = validateValue(definitions, def, obj.Object, "")
+	sort.Strings(problems)
+	return problems, true
+}
+
+// definitionForGVK finds the schema definition of a group version kind, the definitions of top-level
+// resources list the kinds they describe in x-kubernetes-group-version-kind.
+func definitionForGVK(definitions map[string]interface{}, gvk runtimeschema.GroupVersionKind) map[string]interface{} {
+	for _, d := range definitions {
+		def, ok := d.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		gvks, _ := def["x-kubernetes-group-version-kind"].([]interface{})
+		for _, g := range gvks {
+			m, ok := g.(map[string]interface{})
+			if ok && m["group"] == gvk.Group && m["version"] == gvk.Version && m["kind"] == gvk.Kind {
+				return def
+			}
+		}
+	}
+	return nil
+}
+
+// resolveRef follows the $ref of a schema to the referenced definition.
+// OpenAPI v3 documents wrap references in allOf, so the first entry of allOf is followed too.
+func resolveRef(definitions map[string]interface{}, def map[string]interface{}) map[string]interface{} {
+	if allOf, ok := def["allOf"].([]interface{}); ok && len(allOf) > 0 {
+		if first, ok := allOf[0].(map[string]interface{}); ok {
+			def = first
+		}
+	}
+	ref, ok := def["$ref"].(string)
+	if !ok {
+		return def
+	}
+	//refs look like #/definitions/io.k8s.api.core.v1.PodSpec
+	name := ref[strings.LastIndex(ref, "/")+1:]
+	resolved, ok := definitions[name].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return resolved
+}
+
+// quantityDefinition is the definition of resource quantities like the requests and limits of containers
+const quantityDefinition = "io.k8s.apimachinery.pkg.api.resource.Quantity"
+
+// validateValue validates a value of the manifest against its schema definition and returns
+// the unknown fields and type mismatches found, with the path to the field.
+func validateValue(definitions map[string]interface{}, def map[string]interface{}, value interface{}, path string) []string {
+	//quantities are strings in the schema but the API server accepts numbers too, e.g. cpu: 1 or memory: 1024
+	if ref, ok := def["$ref"].(string); ok && ref[strings.LastIndex(ref, "/")+1:] == quantityDefinition {
+		return nonEmpty(scalarMismatch(value, path))
+	}
+	def = resolveRef(definitions, def)
+	if def == nil {
+		return nil
+	}
+	//fields like ports can be either an integer or a string, e.g. targetPort: 80 or targetPort: http
+	if def["x-kubernetes-int-or-string"] == true || def["format"] == "int-or-string" {
+		return nonEmpty(scalarMismatch(value, path))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if problem := typeMismatch(def, "object", path); problem != "" {
+			return []string{problem}
+		}
+		props, _ := def["properties"].(map[string]interface{})
+		additional, hasAdditional := def["additionalProperties"].(map[string]interface{})
+		//an object without properties is free-form, e.g. the raw extension of a custom resource
+		if props == nil && !hasAdditional {
+			return nil
+		}
+		var problems []string
+		for k, child := range v {
+			childPath := joinFieldPath(path, k)
+			if prop, ok := props[k].(map[string]interface{}); ok {
+				problems = append(problems, validateValue(definitions, prop, child, childPath)...)
+			} else if hasAdditional {
+				problems = append(problems, validateValue(definitions, additional, child, childPath)...)
+			} else {
+				problems = append(problems, childPath+": unknown field")
+			}
+		}
+		return problems
+	case []interface{}:
+		if problem := typeMismatch(def, "array", path); problem != "" {
+			return []string{problem}
+		}
+		items, ok := def["items"].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		var problems []string
+		for i, child := range v {
+			problems = append(problems, validateValue(definitions, items, child, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return problems
+	case string:
+		return nonEmpty(typeMismatch(def, "string", path))
+	case bool:
+		return nonEmpty(typeMismatch(def, "boolean", path))
+	case int64, int32, int:
+		return nonEmpty(typeMismatch(def, "integer", path))
+	case float64:
+		return nonEmpty(typeMismatch(def, "number", path))
+	}
+	return nil
+}
+
+// typeMismatch returns a problem if the schema has a type which doesn't match the actual type.
+// Integers are valid numbers, schemas without a type accept everything.
+func typeMismatch(def map[string]interface{}, actual, path string) string {
+	expected, ok := def["type"].(string)
+	if !ok || expected == actual || expected == "number" && actual == "integer" {
+		return ""
+	}
+	return fmt.Sprintf("%s: expected %s, got %s", path, expected, actual)
+}
+
+// scalarMismatch returns a problem unless the value is a string or a number, the values int-or-string
+// fields and quantities accept.
+func scalarMismatch(value interface{}, path string) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return fmt.Sprintf("%s: expected string or integer, got object", path)
+	case []interface{}:
+		return fmt.Sprintf("%s: expected string or integer, got array", path)
+	case bool:
+		return fmt.Sprintf("%s: expected string or integer, got boolean", path)
+	}
+	return ""
+}
+
+// joinFieldPath joins the path of a field with the name of a child field, e.g. spec.replicas.
+func joinFieldPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// nonEmpty returns a slice containing the problem, or nil if there is none.
+func nonEmpty(problem string) []string {
+	if problem == "" {
+		return nil
+	}
+	return []string{problem}
+}
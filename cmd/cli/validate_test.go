@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// testDefinitions returns the core/v1 definitions of testdata/swagger.json, the Pod and Service definitions
+// of a Kubernetes OpenAPI v2 spec with everything they reference and without the descriptions.
+func testDefinitions(t *testing.T) map[string]interface{} {
+	t.Helper()
+	data, err := os.ReadFile("testdata/swagger.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	return schema["definitions"].(map[string]interface{})
+}
+
+func TestValidateObject(t *testing.T) {
+	definitions := testDefinitions(t)
+	tests := []struct {
+		name     string
+		manifest string
+		problems []string
+	}{
+		{
+			name: "quantities as numbers",
+			manifest: `apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+spec:
+  containers:
+  - name: web
+    image: nginx
+    resources:
+      requests:
+        cpu: 1
+        memory: 1024
+      limits:
+        cpu: 0.5
+        memory: 1Gi
+`,
+		},
+		{
+			name: "int-or-string as integer and string",
+			manifest: `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+  - name: http
+    port: 80
+    targetPort: 80
+  - name: https
+    port: 443
+    targetPort: https
+`,
+		},
+		{
+			name: "probe port as integer",
+			manifest: `apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+spec:
+  containers:
+  - name: web
+    image: nginx
+    livenessProbe:
+      httpGet:
+        path: /
+        port: 8080
+`,
+		},
+		{
+			name: "quantity as object",
+			manifest: `apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+spec:
+  containers:
+  - name: web
+    image: nginx
+    resources:
+      limits:
+        cpu:
+          cores: 1
+`,
+			problems: []string{"spec.containers[0].resources.limits.cpu: expected string or integer, got object"},
+		},
+		{
+			name: "unknown field and type mismatch",
+			manifest: `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  selectors:
+    app: web
+  ports:
+  - port: "80"
+`,
+			problems: []string{
+				"spec.ports[0].port: expected integer, got string",
+				"spec.selectors: unknown field",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects, err := decodeManifest(tt.manifest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			problems, found := validateObject(definitions, objects[0])
+			if !found {
+				t.Fatalf("no definition found for %s", objects[0].GetKind())
+			}
+			if !reflect.DeepEqual(problems, tt.problems) {
+				t.Errorf("got problems %q, want %q", problems, tt.problems)
+			}
+		})
+	}
+}
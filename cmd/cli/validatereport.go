@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// unknownFieldSuffix ends the schema problems of fields the schema doesn't know, see validateValue
+const unknownFieldSuffix = ": unknown field"
+
+// validationReport is the result of the validate flag, printed as JSON with output=json
+type validationReport struct {
+	Valid     bool                 `json:"valid"`
+	Error     string               `json:"error,omitempty"`
+	Documents []documentValidation `json:"documents"`
+	TraceID   string               `json:"traceID,omitempty"`
+}
+
+// documentValidation is the result of a single document of the manifest
+type documentValidation struct {
+	Index      int    `json:"index"`
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Valid      bool   `json:"valid"`
+	// SchemaFound is false for objects the schema has no definition for, e.g. custom resources, they aren't validated
+	SchemaFound   bool     `json:"schemaFound"`
+	UnknownFields []string `json:"unknownFields"`
+	Problems      []string `json:"problems"`
+	Lint          []string `json:"lint"`
+}
+
+// validateManifest validates every document of the manifest against the Kubernetes OpenAPI schema and lints it.
+// A document is invalid if it has unknown fields or other schema problems, lint findings are reported but,
+// like everywhere else, don't make a document invalid.
+func validateManifest(completion string) validationReport {
+	report := validationReport{Valid: true, TraceID: traceID, Documents: []documentValidation{}}
+	objects, err := decodeManifest(completion)
+	if err != nil {
+		return validationReport{Error: err.Error(), TraceID: traceID, Documents: []documentValidation{}}
+	}
+	definitions, err := schemaDefinitions()
+	if err != nil {
+		return validationReport{Error: fmt.Sprintf("unable to fetch the schema: %v", err), TraceID: traceID, Documents: []documentValidation{}}
+	}
+
+	for i, obj := range objects {
+		doc := documentValidation{
+			Index:         i,
+			APIVersion:    obj.GetAPIVersion(),
+			Kind:          obj.GetKind(),
+			Name:          obj.GetName(),
+			UnknownFields: []string{},
+			Problems:      []string{},
+			Lint:          []string{},
+		}
+		problems, found := validateObject(definitions, obj)
+		doc.SchemaFound = found
+		for _, problem := range problems {
+			if field, ok := strings.CutSuffix(problem, unknownFieldSuffix); ok {
+				doc.UnknownFields = append(doc.UnknownFields, field)
+			} else {
+				doc.Problems = append(doc.Problems, problem)
+			}
+		}
+		doc.Lint = append(doc.Lint, lintObject(obj)...)
+		doc.Valid = len(problems) == 0
+		report.Valid = report.Valid && doc.Valid
+		report.Documents = append(report.Documents, doc)
+	}
+	return report
+}
+
+// printValidationReport validates the manifest and prints the report, as JSON with output=json.
+// It returns an error if the manifest is invalid, so the exit code can gate a pipeline.
+func printValidationReport(completion string) error {
+	report := validateManifest(completion)
+	if *output == outputJSON {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	} else {
+		printValidationText(report)
+	}
+
+	if report.Error != "" {
+		return fmt.Errorf("unable to validate the manifest: %s", report.Error)
+	}
+	if !report.Valid {
+		var invalid int
+		for _, doc := range report.Documents {
+			if !doc.Valid {
+				invalid++
+			}
+		}
+		return fmt.Errorf("%d of %d documents of the manifest are invalid", invalid, len(report.Documents))
+	}
+	return nil
+}
+
+// printValidationText prints the report for humans, a line per document followed by its findings.
+func printValidationText(report validationReport) {
+	for _, doc := range report.Documents {
+		status := emoji("✅") + "valid"
+		switch {
+		case !doc.Valid:
+			status = emoji("❌") + "invalid"
+		case !doc.SchemaFound:
+			status = "not validated, no schema found"
+		}
+		fmt.Printf("%s/%s: %s\n", doc.Kind, doc.Name, status)
+		for _, field := range doc.UnknownFields {
+			fmt.Printf("  - %s%s\n", field, unknownFieldSuffix)
+		}
+		for _, problem := range doc.Problems {
+			fmt.Printf("  - %s\n", problem)
+		}
+		for _, finding := range doc.Lint {
+			fmt.Printf("  - lint: %s\n", finding)
+		}
+	}
+}
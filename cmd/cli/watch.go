@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// watchDebounce is how long we wait after the last change of the prompt file before running again,
+// editors often write a file several times (or rename and recreate it) on a single save
+const watchDebounce = 500 * time.Millisecond
+
+// watchPromptFile runs the generate/apply pipeline once and then again every time the prompt file changes.
+// It returns when the user presses Ctrl-C or the watcher fails.
+func watchPromptFile(args []string) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	//we watch the directory instead of the file itself, because editors that save by renaming
+	//a temporary file over the original would otherwise remove the file from the watcher
+	path, err := filepath.Abs(*promptFile)
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	runOnce := func() {
+		//a failed run shouldn't stop watching, the next save may fix the prompt
+		if err := run(args); err != nil {
+			log.Errorf("run failed: %v", err)
+		}
+		fmt.Printf("%sWatching %s for changes...\n", emoji("👀"), *promptFile)
+	}
+	runOnce()
+
+	//the timer is started on the first change and reset on every following change,
+	//so we only run once the file stopped changing for watchDebounce
+	debounce := time.NewTimer(watchDebounce)
+	debounce.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Name != path || !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+				continue
+			}
+			log.Debugf("prompt file changed: %s", event)
+			debounce.Reset(watchDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		case <-debounce.C:
+			runOnce()
+		}
+	}
+}